@@ -0,0 +1,205 @@
+package gobble
+
+import (
+	"cmp"
+)
+
+// Aggregation
+//
+// The aggregation functions go through the records one at a time like Scan, keeping only what they compute. They hold
+// the collection's read lock while they run, so the functions passed to them must not write to it. A nil query stands
+// for all the records.
+
+// Number is the constraint of the values Sum can add up.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// Reducer folds a record into the accumulated value of its group, see GroupBy. The first record of a group is folded
+// into the zero value of A.
+type Reducer[T any, A any] func(acc A, data T) A
+
+// groupIndex is what GroupByIndex needs from an index with keys of type K
+type groupIndex[T any, K comparable] interface {
+	AnyIndex[T]
+	collection() *Collection[T]
+	// groups calls fn with every key of the index and the file IDs of its records, until it returns false
+	groups(fn func(key K, fileIDs []string) bool) error
+}
+
+// forEach calls fn with every record query returns true for, or every record if it is nil, in ID order
+func (t *Collection[T]) forEach(query Query[T], fn func(data T)) error {
+	t.state.mu.RLock()
+	defer t.state.mu.RUnlock()
+
+	ids, err := t.state.store.ids()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		data, err := t.read(id)
+		if err != nil {
+			return err
+		}
+
+		if query == nil || query(data) {
+			fn(data)
+		}
+	}
+
+	return nil
+}
+
+// Count returns the number of records query returns true for. With a nil query it counts all records without
+// reading them, like Number.
+func (t *Collection[T]) Count(query Query[T]) (int, error) {
+	if query == nil {
+		return t.Number()
+	}
+
+	n := 0
+	err := t.forEach(query, func(T) { n++ })
+	return n, err
+}
+
+// CountWhere returns the number of records matching q. If the indices can answer q on their own (it has no Filter,
+// and Explain shows nothing to check on the records), no record is read.
+func (t *Collection[T]) CountWhere(q *IndexQuery[T]) (int, error) {
+	t.state.mu.RLock()
+	defer t.state.mu.RUnlock()
+
+	if err := t.checkIndexQuery(q); err != nil {
+		return 0, err
+	}
+
+	p, err := q.plan(0)
+	if err != nil {
+		return 0, err
+	}
+
+	fileIDs, err := p.fileIDs()
+	if err != nil {
+		return 0, err
+	}
+	if !q.hasFilter() && p.exact() {
+		return len(fileIDs), nil
+	}
+
+	n := 0
+	for fileID := range fileIDs {
+		data, err := t.readFileID(fileID)
+		if err != nil {
+			return 0, err
+		}
+		if q.match(data) {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// Sum adds up value for the records query returns true for, all of them if it is nil.
+func Sum[T any, N Number](c *Collection[T], query Query[T], value func(T) N) (N, error) {
+	var sum N
+	err := c.forEach(query, func(data T) { sum += value(data) })
+	return sum, err
+}
+
+// Min returns the first of the records query returns true for (all of them if it is nil) with the smallest key, or
+// ErrNotFound if there are none.
+func Min[T any, K cmp.Ordered](c *Collection[T], query Query[T], key func(T) K) (T, error) {
+	return extreme(c, query, key, -1)
+}
+
+// Max returns the first of the records query returns true for (all of them if it is nil) with the largest key, or
+// ErrNotFound if there are none.
+func Max[T any, K cmp.Ordered](c *Collection[T], query Query[T], key func(T) K) (T, error) {
+	return extreme(c, query, key, 1)
+}
+
+// extreme returns the first record whose key compares to all others as sign says
+func extreme[T any, K cmp.Ordered](c *Collection[T], query Query[T], key func(T) K, sign int) (T, error) {
+	var best T
+	var bestKey K
+	found := false
+
+	err := c.forEach(query, func(data T) {
+		k := key(data)
+		if !found || cmp.Compare(k, bestKey) == sign {
+			best, bestKey, found = data, k, true
+		}
+	})
+	if err != nil {
+		return best, err
+	}
+	if !found {
+		return best, ErrNotFound
+	}
+
+	return best, nil
+}
+
+// Distinct returns the different keys of the records query returns true for (all of them if it is nil), in the order
+// they first appear.
+func Distinct[T any, K comparable](c *Collection[T], query Query[T], key func(T) K) ([]K, error) {
+	seen := map[K]bool{}
+	keys := []K{}
+
+	err := c.forEach(query, func(data T) {
+		if k := key(data); !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	})
+
+	return keys, err
+}
+
+// GroupBy groups the records of the collection by the key returned for each of them, and folds the records of every
+// group with reducer. For example, to count the records of each group:
+//
+//	GroupBy(&people, func(p Person) string { return p.City }, func(n int, _ Person) int { return n + 1 })
+func GroupBy[T any, K comparable, A any](c *Collection[T], key func(T) K, reducer Reducer[T, A]) (map[K]A, error) {
+	groups := map[K]A{}
+	err := c.forEach(nil, func(data T) {
+		k := key(data)
+		groups[k] = reducer(groups[k], data)
+	})
+
+	return groups, err
+}
+
+// GroupByIndex is GroupBy with the keys of an index (any index but a TextIndex), which are taken from the index
+// instead of being computed for every record. With a MultiIndex a record is in the group of each of its keys.
+func GroupByIndex[T any, K comparable, A any](index groupIndex[T, K], reducer Reducer[T, A]) (map[K]A, error) {
+	c := index.collection()
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	groups := map[K]A{}
+	var readErr error
+	err := index.groups(func(key K, fileIDs []string) bool {
+		var acc A
+		for _, fileID := range fileIDs {
+			data, err := c.readFileID(fileID)
+			if err != nil {
+				readErr = err
+				return false
+			}
+			acc = reducer(acc, data)
+		}
+		groups[key] = acc
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	return groups, nil
+}
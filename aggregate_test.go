@@ -0,0 +1,76 @@
+package gobble
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestAggregation(t *testing.T) {
+	db, _ := OpenDB("testdb-aggregate")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-aggregate")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	for i := 0; i < 30; i++ {
+		_ = c.Insert(ExamplePersonStruct{Name: fmt.Sprintf("Person %d", i%5), Age: i})
+	}
+	adults := func(p ExamplePersonStruct) bool { return p.Age >= 18 }
+	age := func(p ExamplePersonStruct) int { return p.Age }
+	name := func(p ExamplePersonStruct) string { return p.Name }
+
+	if n, _ := c.Count(adults); n != 12 {
+		t.Fatalf("expected 12 adults, got %d", n)
+	}
+	if n, _ := c.Count(nil); n != 30 {
+		t.Fatalf("expected 30 records, got %d", n)
+	}
+	if sum, _ := Sum(&c, adults, age); sum != 282 {
+		t.Fatalf("expected ages adding up to 282, got %d", sum)
+	}
+	if sum, _ := Sum(&c, nil, age); sum != 435 {
+		t.Fatalf("expected all ages adding up to 435, got %d", sum)
+	}
+	if p, _ := Min(&c, adults, age); p.Age != 18 {
+		t.Fatalf("unexpected youngest adult %v", p)
+	}
+	if p, _ := Max(&c, adults, name); p.Name != "Person 4" || p.Age != 19 {
+		t.Fatalf("unexpected max name %v", p)
+	}
+	if _, err := Max(&c, func(p ExamplePersonStruct) bool { return false }, age); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if names, _ := Distinct(&c, adults, name); !slices.Equal(names, []string{"Person 3", "Person 4", "Person 0", "Person 1", "Person 2"}) {
+		t.Fatalf("unexpected distinct names %v", names)
+	}
+	if p, _ := Max(&c, nil, age); p.Age != 29 {
+		t.Fatalf("unexpected oldest %v", p)
+	}
+	if names, _ := Distinct(&c, nil, name); len(names) != 5 {
+		t.Fatalf("unexpected distinct names %v", names)
+	}
+
+	count := func(n int, _ ExamplePersonStruct) int { return n + 1 }
+	groups, _ := GroupBy(&c, func(p ExamplePersonStruct) bool { return p.Age >= 18 }, count)
+	if groups[true] != 12 || groups[false] != 18 {
+		t.Fatalf("unexpected groups %v", groups)
+	}
+
+	byName, _ := OpenIndex[ExamplePersonStruct, string](&c, "name", name)
+	oldest, _ := GroupByIndex(byName, func(oldest int, p ExamplePersonStruct) int { return max(oldest, p.Age) })
+	if len(oldest) != 5 || oldest["Person 0"] != 25 || oldest["Person 4"] != 29 {
+		t.Fatalf("unexpected groups %v", oldest)
+	}
+
+	// Answered from the indices alone, or checked on the records
+	byAge, _ := OpenOrderedIndex[ExamplePersonStruct, int](&c, "age", age)
+	if n, _ := c.CountWhere(Where(byAge, Ge, 18).And(Where(byName, Eq, "Person 0"))); n != 2 {
+		t.Fatalf("expected 2 records, got %d", n)
+	}
+	if n, _ := c.CountWhere(Where(byAge, Ge, 18).Filter(func(p ExamplePersonStruct) bool { return p.Age%2 == 0 })); n != 6 {
+		t.Fatalf("expected 6 records, got %d", n)
+	}
+}
@@ -30,7 +30,7 @@ func TestBenchmark(t *testing.T) {
 	}
 	timing("Insert 10000 records (before indexing)")
 
-	index, _ := OpenIndex[ExamplePersonStruct, string](&collection, func(p ExamplePersonStruct) string {
+	index, _ := OpenIndex[ExamplePersonStruct, string](&collection, "name", func(p ExamplePersonStruct) string {
 		return p.Name
 	})
 	timing("Index init")
@@ -0,0 +1,216 @@
+package gobble
+
+import (
+	"os"
+	"sort"
+)
+
+// Compaction rewrites the live records of a SegmentStorage collection into new segments, leaving behind old versions
+// of modified records and tombstones, and then removes the old segments.
+//
+// The new segments are written under temporary names and renamed into place before any old segment is removed.
+// Records keep their sequence numbers, so if the process stops half way through, replaying the leftover old segments
+// next to the new ones gives the same result. Old segments are removed oldest first, so a tombstone is never
+// removed before the older versions of the record it deletes.
+
+// Compact rewrites a SegmentStorage collection without the space taken by old versions of modified or deleted records,
+// and returns the number of bytes reclaimed. It does nothing for FileStorage collections, which don't leave any behind.
+func (t *Collection[T]) Compact() (int64, error) {
+	if t.state.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	c, ok := t.state.store.(compacter)
+	if !ok {
+		return 0, nil
+	}
+
+	return c.compact()
+}
+
+func (t *segmentStore) compact() (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.compactLocked()
+}
+
+// maybeCompact starts a background compaction if enough of the segments is dead space, t.mu must be held
+func (t *segmentStore) maybeCompact() {
+	if t.threshold <= 0 || t.compacting || t.closing {
+		return
+	}
+
+	dead := t.totalBytes - t.liveBytes
+	if dead < t.maxSize || float64(dead) < t.threshold*float64(t.totalBytes) {
+		return
+	}
+
+	t.compacting = true
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.compacting = false
+		if t.closed {
+			return
+		}
+
+		if _, err := t.compactLocked(); err != nil {
+			t.compactErr = err
+		}
+	}()
+}
+
+func (t *segmentStore) compactLocked() (int64, error) {
+	if t.closed {
+		return 0, nil
+	}
+
+	// Tombstones aren't carried over, so the IDs and sequence numbers they held would otherwise be used again after a
+	// restart
+	meta, err := readMetadata(t.dir)
+	if err != nil {
+		return 0, err
+	}
+	if meta.LastID < t.lastID || meta.Version < t.seq {
+		meta.LastID = max(meta.LastID, t.lastID)
+		meta.Version = max(meta.Version, t.seq)
+		if err := writeMetadata(t.dir, meta); err != nil {
+			return 0, err
+		}
+	}
+
+	oldNums := make([]int, 0, len(t.files))
+	for num := range t.files {
+		oldNums = append(oldNums, num)
+	}
+	sort.Ints(oldNums)
+
+	ids := make([]int, 0, len(t.offsets))
+	for id := range t.offsets {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var (
+		newNums    []int
+		newFiles   = map[int]*os.File{}
+		tmpPaths   = map[int]string{}
+		newOffsets = make(map[int]segmentLocation, len(ids))
+		num        = t.active
+		size       int64
+		total      int64
+	)
+
+	cleanup := func() {
+		for _, n := range newNums {
+			_ = newFiles[n].Close()
+			_ = os.Remove(tmpPaths[n])
+			_ = os.Remove(t.segmentPath(n))
+		}
+	}
+
+	for _, id := range ids {
+		loc := t.offsets[id]
+
+		payload := make([]byte, loc.size)
+		if _, err := t.files[loc.segment].ReadAt(payload, loc.offset); err != nil {
+			cleanup()
+			return 0, err
+		}
+
+		if len(newNums) == 0 || size >= t.maxSize {
+			num++
+			f, err := os.CreateTemp(t.dir, tempFilePrefix+"*")
+			if err != nil {
+				cleanup()
+				return 0, err
+			}
+			newNums = append(newNums, num)
+			newFiles[num] = f
+			tmpPaths[num] = f.Name()
+			size = 0
+		}
+
+		rec := encodeSegmentRecord(segmentOpPut, id, loc.seq, payload)
+		if _, err := newFiles[num].WriteAt(rec, size); err != nil {
+			cleanup()
+			return 0, err
+		}
+
+		newOffsets[id] = segmentLocation{segment: num, offset: size + segmentHeaderSize, size: loc.size, seq: loc.seq}
+		size += int64(len(rec))
+		total += int64(len(rec))
+	}
+
+	for _, n := range newNums {
+		if err := newFiles[n].Sync(); err != nil {
+			cleanup()
+			return 0, err
+		}
+	}
+
+	// The files stay open across the rename, and are used for reading and appending afterwards
+	for _, n := range newNums {
+		if err := os.Rename(tmpPaths[n], t.segmentPath(n)); err != nil {
+			cleanup()
+			return 0, err
+		}
+	}
+	if err := syncDir(t.dir); err != nil {
+		cleanup()
+		return 0, err
+	}
+
+	// From here on the new segments hold everything. If removing an old segment fails, the newer ones are kept too so
+	// that no old version of a record outlives the tombstone that deleted it, and they are tried again by the next compaction.
+	var removeErr error
+	for _, n := range oldNums {
+		if removeErr == nil {
+			removeErr = os.Remove(t.segmentPath(n))
+			if removeErr == nil {
+				_ = t.files[n].Close()
+				continue
+			}
+		}
+
+		newFiles[n] = t.files[n]
+		if info, err := t.files[n].Stat(); err == nil {
+			total += info.Size()
+		}
+	}
+	if err := syncDir(t.dir); err != nil && removeErr == nil {
+		removeErr = err
+	}
+
+	reclaimed := t.totalBytes - total
+
+	// The new segments are synced already
+	for num := range t.unsynced {
+		if _, ok := newFiles[num]; !ok {
+			delete(t.unsynced, num)
+		}
+	}
+
+	t.files = newFiles
+	t.offsets = newOffsets
+	t.totalBytes = total
+	t.liveBytes = 0
+	for _, loc := range newOffsets {
+		t.liveBytes += segmentHeaderSize + int64(loc.size)
+	}
+	t.active = num
+	t.activeSize = size
+
+	if len(newNums) == 0 {
+		if err := t.rotate(); err != nil {
+			return reclaimed, err
+		}
+	}
+
+	return reclaimed, removeErr
+}
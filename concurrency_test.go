@@ -0,0 +1,144 @@
+package gobble
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+// These tests are most useful with the race detector: go test -race -run Concurrent
+
+func testConcurrentCollection(t *testing.T, path string, opts ...Option) {
+	db, _ := OpenDB(path, opts...)
+	defer func() { _ = os.RemoveAll(path) }()
+	defer func() { _ = db.Close() }()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	i1, _ := OpenIndex[ExamplePersonStruct, string](&c, "name", func(p ExamplePersonStruct) string {
+		return p.Name
+	})
+	i2, _ := OpenIndex[ExamplePersonStruct, int](&c, "age", func(p ExamplePersonStruct) int {
+		return p.Age
+	})
+
+	const writers = 8
+	const perWriter = 25
+
+	var wg sync.WaitGroup
+	ids := make(chan int, writers*perWriter)
+	errs := make(chan error, writers*perWriter*4)
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				id, err := c.InsertWithID(ExamplePersonStruct{Name: fmt.Sprintf("ExamplePersonStruct %d %d", w, i), Age: w})
+				if err != nil {
+					errs <- err
+					continue
+				}
+				ids <- id
+			}
+		}(w)
+
+		// Readers running alongside the writers
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				if _, err := c.Select(func(p ExamplePersonStruct) bool { return p.Age == w }); err != nil {
+					errs <- err
+				}
+				if _, err := i1.Get(fmt.Sprintf("ExamplePersonStruct %d %d", w, i)); err != nil {
+					errs <- err
+				}
+				if _, err := i2.Num(w); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(ids)
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	seen := map[int]bool{}
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("ID %d handed out twice", id)
+		}
+		seen[id] = true
+	}
+	if n, _ := c.Number(); n != writers*perWriter {
+		t.Fatalf("expected %d records, got %d", writers*perWriter, n)
+	}
+
+	// Concurrent writers going through indices and queries
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			if w%2 == 0 {
+				_ = i2.Del(w)
+			} else {
+				_ = i2.Mod(w, func(p ExamplePersonStruct) ExamplePersonStruct { p.Age += 100; return p })
+			}
+			_ = c.Modify(func(p ExamplePersonStruct) bool { return p.Name == fmt.Sprintf("ExamplePersonStruct %d 0", w) },
+				func(p ExamplePersonStruct) ExamplePersonStruct { p.Name += " modified"; return p })
+		}(w)
+	}
+	wg.Wait()
+
+	if n, _ := c.Number(); n != writers/2*perWriter {
+		t.Fatalf("expected %d records, got %d", writers/2*perWriter, n)
+	}
+	for w := 1; w < writers; w += 2 {
+		if n, _ := i2.Num(w + 100); n != perWriter {
+			t.Fatalf("expected %d records with age %d, got %d", perWriter, w+100, n)
+		}
+		if n, _ := i1.Num(fmt.Sprintf("ExamplePersonStruct %d 0 modified", w)); n != 1 {
+			t.Fatalf("expected modified record of writer %d in the index", w)
+		}
+	}
+}
+
+func TestConcurrentFileStorage(t *testing.T) {
+	testConcurrentCollection(t, "testdb-concurrent-file")
+}
+
+func TestConcurrentSegmentStorage(t *testing.T) {
+	testConcurrentCollection(t, "testdb-concurrent-seg", WithStorage(SegmentStorage), WithSegmentSize(2048))
+}
+
+func TestConcurrentOpenCollection(t *testing.T) {
+	db, _ := OpenDB("testdb-concurrent-open")
+	defer func() { _ = os.RemoveAll("testdb-concurrent-open") }()
+
+	// Collections opened separately from the same DB share their lock, so IDs stay unique
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := OpenCollection[ExamplePersonStruct](db, "testcollection")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for i := 0; i < 10; i++ {
+				_ = c.Insert(ExamplePersonStruct{Name: "ExamplePersonStruct", Age: i})
+			}
+		}()
+	}
+	wg.Wait()
+
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	if n, _ := c.Number(); n != 40 {
+		t.Fatalf("expected 40 records, got %d", n)
+	}
+}
@@ -15,6 +15,7 @@ type Shape struct {
 
 func main() {
 	db, _ := gobble.OpenDB("test-db")
+	defer db.Close() // saves the indexes and releases the lock on the directory
 
 	// Pass in your struct as a type parameter
 	shapes, _ := gobble.OpenCollection[Shape](db, "shapes")
@@ -40,8 +41,9 @@ func main() {
 	// Indexing
 	// Indexing is done by passing in a function that takes in your struct and returns a value to index on <- that's an "extractor" function
 	// The first type parameter is the type of the struct, and the second type parameter is the type of the index (in this case, string)
-	// collection: *Collection[Shape], extractor: func(Shape) string -> (Index[Shape, string], error)
-	nameIndex, _ := gobble.OpenIndex[Shape, string](&shapes, func(shape Shape) string { return shape.Name })
+	// Every index has a name, which it is saved under and can be looked up by later
+	// collection: *Collection[Shape], name: string, extractor: func(Shape) string -> (*Index[Shape, string], error)
+	nameIndex, _ := gobble.OpenIndex[Shape, string](&shapes, "name", func(shape Shape) string { return shape.Name })
 
 	// Now you can query the index by passing Get a value of the type that your extractor function returns (in this case, string)
 	// key: string -> ([]Shape, error)
@@ -49,8 +51,8 @@ func main() {
 
 	// You can do more with indexes
 	// This index has a key type of int (that represents the sum of the side lengths of the shape)
-	// collection: *Collection[Shape], extractor: func(Shape) int -> (Index[Shape, int], error)
-	perimeterIndex, _ := gobble.OpenIndex[Shape, int](&shapes, func(u Shape) int {
+	// collection: *Collection[Shape], name: string, extractor: func(Shape) int -> (*Index[Shape, int], error)
+	perimeterIndex, _ := gobble.OpenIndex[Shape, int](&shapes, "perimeter", func(u Shape) int {
 		sum := 0
 		for _, l := range u.SideLengths {
 			sum += l
@@ -67,4 +69,8 @@ func main() {
 	nameIndex.Del("Square") // Deletes all elements that match the key
 	nameIndex.Mod("Square", // Modifies all elements that match the key, takes an updater function
 		func(s Shape) Shape { s.Name = "Still a Square"; return s })
+
+	shapes.ListIndices()          // Returns the names of the open indexes, ["name" "perimeter"]
+	shapes.Index("name")          // Returns an open index by name
+	shapes.DropIndex("perimeter") // Stops maintaining the index and removes it from disk
 }
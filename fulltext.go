@@ -0,0 +1,352 @@
+package gobble
+
+import (
+	"cmp"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Full-text indices
+//
+// A TextIndex splits the text it is given for every record into terms, and keeps for every term the records it appears
+// in and at which positions (an inverted index). Searches rank the records they find with BM25, which favours records
+// that have the query's terms often, terms that few records have, and short texts.
+
+// BM25 parameters, the usual defaults
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// TextIndex is a full-text index over the text its extractor returns for each record, see OpenTextIndex.
+// It is safe for concurrent use like its collection.
+type TextIndex[T any] struct {
+	Collection *Collection[T]
+	Name       string
+	Extractor  func(T) string
+
+	opts     textOptions
+	postings map[string]map[string][]int // term -> file ID -> positions of the term in the record's text, ascending
+	lengths  map[string]int              // file ID -> number of terms in the record's text
+	total    int                         // sum of lengths
+
+	indexFile
+}
+
+// TextResult is a record found by TextIndex.Search, with its ID and how well it matched the query.
+type TextResult[T any] struct {
+	ID    int
+	Data  T
+	Score float64
+}
+
+// TextOption configures OpenTextIndex.
+type TextOption func(*textOptions)
+
+type textOptions struct {
+	tokenizer  func(string) []string
+	normalizer func(string) string
+	stemmer    func(string) string
+}
+
+// WithTokenizer sets the function that splits text into words. By default words are runs of letters and digits.
+func WithTokenizer(tokenizer func(string) []string) TextOption {
+	return func(o *textOptions) {
+		o.tokenizer = tokenizer
+	}
+}
+
+// WithNormalizer sets the function applied to every word, strings.ToLower by default so searches ignore case.
+func WithNormalizer(normalizer func(string) string) TextOption {
+	return func(o *textOptions) {
+		o.normalizer = normalizer
+	}
+}
+
+// WithStemmer sets a function applied to every normalized word, for example to reduce it to its stem so that "tickets"
+// finds "ticket". Words it returns "" for are left out, which can be used for stop words. There is none by default.
+func WithStemmer(stemmer func(string) string) TextOption {
+	return func(o *textOptions) {
+		o.stemmer = stemmer
+	}
+}
+
+// same returns whether o has the same functions as other. Functions can't be compared, so this compares their code,
+// which tells WithStemmer(a) from WithStemmer(b), but not two closures of the same function.
+func (o textOptions) same(other textOptions) bool {
+	return sameFunc(o.tokenizer, other.tokenizer) && sameFunc(o.normalizer, other.normalizer) &&
+		sameFunc(o.stemmer, other.stemmer)
+}
+
+func sameFunc[F any](a, b F) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
+
+type textSnapshot struct {
+	Kind     string
+	Version  uint64
+	Postings map[string]map[string][]int
+}
+
+// OpenTextIndex opens the full-text index of the collection called name over the text extractor returns for each
+// record (to search several fields, join them). Like an Index, it is kept up to date by all writes to the collection
+// and saved to disk. The name identifies the index, if the extractor or the options change, so should the name.
+func OpenTextIndex[T any](c *Collection[T], name string, extractor func(T) string, opts ...TextOption) (*TextIndex[T], error) {
+	o := textOptions{tokenizer: tokenize, normalizer: strings.ToLower}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return openIndex(c, name, func(index *TextIndex[T]) bool {
+		return index.opts.same(o)
+	}, func() (*TextIndex[T], error) {
+		i := &TextIndex[T]{Collection: c, Name: name, Extractor: extractor, opts: o, lengths: map[string]int{}}
+		i.indexFile = newIndexFile(c, name, kindText)
+
+		var snapshot textSnapshot
+		ok, err := readSnapshot(i.path, &snapshot)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok && snapshot.Kind == i.kind && snapshot.Version == i.savedVersion {
+			i.postings = snapshot.Postings
+			if i.postings == nil {
+				i.postings = map[string]map[string][]int{}
+			}
+			for _, records := range i.postings {
+				for fileID, positions := range records {
+					i.lengths[fileID] += len(positions)
+					i.total += len(positions)
+				}
+			}
+			i.saved = true
+			return i, nil
+		}
+
+		i.postings = map[string]map[string][]int{}
+		ids, err := c.state.store.ids()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			data, err := c.read(id)
+			if err != nil {
+				return nil, err
+			}
+			i.add(strconv.Itoa(id), data)
+		}
+
+		return i, nil
+	})
+}
+
+// tokenize splits text into runs of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// terms splits text into the terms the index keeps, in order
+func (t *TextIndex[T]) terms(text string) []string {
+	words := t.opts.tokenizer(text)
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		term := t.opts.normalizer(word)
+		if t.opts.stemmer != nil {
+			term = t.opts.stemmer(term)
+		}
+		if term != "" {
+			terms = append(terms, term)
+		}
+	}
+
+	return terms
+}
+
+// Search returns the records matching query, the best match first. If limit is more than 0, at most that many are
+// returned.
+//
+// Every word of the query is a term, and matches the records that have it. A word ending in '*' matches the records
+// with any term starting with it, and words in double quotes match the records that have them next to each other in
+// that order. A record is returned if it matches any of them, and scores higher the more it matches.
+func (t *TextIndex[T]) Search(query string, limit int) ([]TextResult[T], error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
+	scores := map[string]float64{}
+	for _, part := range t.parseQuery(query) {
+		switch {
+		case part.prefix != "":
+			for term := range t.postings {
+				if strings.HasPrefix(term, part.prefix) {
+					t.scoreTerm(term, scores)
+				}
+			}
+		case len(part.terms) == 1:
+			t.scoreTerm(part.terms[0], scores)
+		default:
+			t.scorePhrase(part.terms, scores)
+		}
+	}
+
+	results := make([]TextResult[T], 0, len(scores))
+	for fileID, score := range scores {
+		id, err := strconv.Atoi(fileID)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, TextResult[T]{ID: id, Score: score})
+	}
+
+	slices.SortFunc(results, func(a, b TextResult[T]) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	for i := range results {
+		data, err := t.Collection.read(results[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		results[i].Data = data
+	}
+
+	return results, nil
+}
+
+// textQueryPart is a term, a phrase (more than one term) or a prefix of a query
+type textQueryPart struct {
+	terms  []string
+	prefix string
+}
+
+func (t *TextIndex[T]) parseQuery(query string) []textQueryPart {
+	var parts []textQueryPart
+
+	// Every second piece is inside double quotes
+	for i, piece := range strings.Split(query, `"`) {
+		if i%2 == 1 {
+			if terms := t.terms(piece); len(terms) > 0 {
+				parts = append(parts, textQueryPart{terms: terms})
+			}
+			continue
+		}
+
+		for _, word := range strings.Fields(piece) {
+			if prefix, ok := strings.CutSuffix(word, "*"); ok {
+				// Not stemmed, the stem of a prefix isn't a prefix of the stems of the words it starts
+				if prefix = t.opts.normalizer(prefix); prefix != "" {
+					parts = append(parts, textQueryPart{prefix: prefix})
+				}
+				continue
+			}
+
+			for _, term := range t.terms(word) {
+				parts = append(parts, textQueryPart{terms: []string{term}})
+			}
+		}
+	}
+
+	return parts
+}
+
+// bm25 scores a record for a term or phrase it has tf times, which df of the records have
+func (t *TextIndex[T]) bm25(tf int, df int, fileID string) float64 {
+	n := float64(len(t.lengths))
+	avgLength := float64(t.total) / n
+	idf := math.Log(1 + (n-float64(df)+0.5)/(float64(df)+0.5))
+	length := float64(t.lengths[fileID])
+
+	return idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*(1-bm25B+bm25B*length/avgLength))
+}
+
+func (t *TextIndex[T]) scoreTerm(term string, scores map[string]float64) {
+	records := t.postings[term]
+	for fileID, positions := range records {
+		scores[fileID] += t.bm25(len(positions), len(records), fileID)
+	}
+}
+
+func (t *TextIndex[T]) scorePhrase(terms []string, scores map[string]float64) {
+	counts := map[string]int{}
+	for fileID, positions := range t.postings[terms[0]] {
+		for _, start := range positions {
+			if t.phraseAt(terms, fileID, start) {
+				counts[fileID]++
+			}
+		}
+	}
+
+	for fileID, count := range counts {
+		scores[fileID] += t.bm25(count, len(counts), fileID)
+	}
+}
+
+// phraseAt returns whether the record has the terms one after the other from position start
+func (t *TextIndex[T]) phraseAt(terms []string, fileID string, start int) bool {
+	for i, term := range terms[1:] {
+		if _, ok := slices.BinarySearch(t.postings[term][fileID], start+i+1); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *TextIndex[T]) add(fileID string, data T) {
+	terms := t.terms(t.Extractor(data))
+	if len(terms) == 0 {
+		return
+	}
+
+	for pos, term := range terms {
+		records, ok := t.postings[term]
+		if !ok {
+			records = map[string][]int{}
+			t.postings[term] = records
+		}
+		records[fileID] = append(records[fileID], pos)
+	}
+
+	t.lengths[fileID] = len(terms)
+	t.total += len(terms)
+}
+
+func (t *TextIndex[T]) remove(fileID string, data T) {
+	for _, term := range t.terms(t.Extractor(data)) {
+		records := t.postings[term]
+		delete(records, fileID)
+		if len(records) == 0 {
+			delete(t.postings, term)
+		}
+	}
+
+	t.total -= t.lengths[fileID]
+	delete(t.lengths, fileID)
+}
+
+func (t *TextIndex[T]) save() error {
+	return t.saveSnapshot(t.Collection.state.store.version(), func(version uint64) any {
+		return textSnapshot{Kind: t.kind, Version: version, Postings: t.postings}
+	})
+}
+
+func (t *TextIndex[T]) drop() {
+	t.dropped = true
+	t.postings, t.lengths, t.total = map[string]map[string][]int{}, map[string]int{}, 0
+}
@@ -0,0 +1,110 @@
+package gobble
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+type noteStruct struct {
+	Title string
+	Body  string
+}
+
+func TestTextIndex(t *testing.T) {
+	db, _ := OpenDB("testdb-fulltext")
+	defer func() {
+		_ = os.RemoveAll("testdb-fulltext")
+	}()
+	c, _ := OpenCollection[noteStruct](db, "notes")
+
+	_ = c.Insert(noteStruct{"Login", "The login page shows an error code after the password reset."})
+	_ = c.Insert(noteStruct{"Billing", "Invoices are sent twice. Billing error, billing error, billing error!"})
+	_ = c.Insert(noteStruct{"Emails", "Password reset emails arrive late"})
+
+	stem := func(word string) string {
+		if word == "the" {
+			return ""
+		}
+		return strings.TrimSuffix(word, "s")
+	}
+	text := func(n noteStruct) string { return n.Title + " " + n.Body }
+	notes, err := OpenTextIndex[noteStruct](&c, "text", text, WithStemmer(stem))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	titles := func(results []TextResult[noteStruct]) string {
+		var s []string
+		for _, r := range results {
+			s = append(s, r.Data.Title)
+		}
+		return strings.Join(s, ",")
+	}
+
+	if x, _ := notes.Search("ERROR", 0); titles(x) != "Billing,Login" || x[0].Score <= x[1].Score {
+		t.Fatalf("unexpected results for error: %v", x)
+	}
+	if x, _ := notes.Search("emails", 0); titles(x) != "Emails" || x[0].ID != 3 {
+		t.Fatalf("unexpected results for emails: %v", x)
+	}
+	if x, _ := notes.Search(`"password reset"`, 0); titles(x) != "Emails,Login" {
+		t.Fatalf("unexpected results for the phrase: %v", titles(x))
+	}
+	if x, _ := notes.Search(`"reset password"`, 0); len(x) != 0 {
+		t.Fatalf("unexpected results for the reversed phrase: %v", titles(x))
+	}
+	if x, _ := notes.Search("invoi*", 0); titles(x) != "Billing" {
+		t.Fatalf("unexpected results for the prefix: %v", titles(x))
+	}
+	if x, _ := notes.Search("password error", 1); len(x) != 1 {
+		t.Fatalf("limit not applied: %v", titles(x))
+	}
+
+	// Kept up to date by writes
+	_ = c.Modify(func(n noteStruct) bool { return n.Title == "Billing" }, func(n noteStruct) noteStruct {
+		n.Body = "Fixed"
+		return n
+	})
+	_ = c.Insert(noteStruct{"Search", "Search results show an error"})
+	if x, _ := notes.Search("error", 0); titles(x) != "Login,Search" && titles(x) != "Search,Login" {
+		t.Fatalf("unexpected results after writes: %v", titles(x))
+	}
+	_ = db.Close()
+
+	db, _ = OpenDB("testdb-fulltext")
+	c, _ = OpenCollection[noteStruct](db, "notes")
+	notes, _ = OpenTextIndex[noteStruct](&c, "text", text, WithStemmer(stem))
+	if x, _ := notes.Search("fixed", 0); titles(x) != "Billing" {
+		t.Fatalf("unexpected results after reopening: %v", titles(x))
+	}
+	_ = db.Close()
+}
+
+func TestTextIndexReopened(t *testing.T) {
+	db, _ := OpenDB("testdb-fulltext-reopen")
+	defer func() {
+		_ = os.RemoveAll("testdb-fulltext-reopen")
+	}()
+	c, _ := OpenCollection[noteStruct](db, "notes")
+	_ = c.Insert(noteStruct{"Alice", "Notes of Alice"})
+
+	title := func(n noteStruct) string { return n.Title }
+	index, _ := OpenTextIndex(&c, "title", title)
+	if again, err := OpenTextIndex(&c, "title", title); err != nil || again != index {
+		t.Fatalf("expected the open index, got %v", err)
+	}
+	if _, err := OpenTextIndex(&c, "title", title, WithStemmer(strings.ToUpper)); err == nil {
+		t.Fatal("expected an error opening the index with different options")
+	}
+	_ = db.Close()
+
+	// The saved text index isn't loaded as another kind of index with the same name
+	db, _ = OpenDB("testdb-fulltext-reopen")
+	c, _ = OpenCollection[noteStruct](db, "notes")
+	prefix, _ := OpenPrefixIndex(&c, "title", title, WithCaseFolding())
+	if found, _ := prefix.Prefix("ali", 0); len(found) != 1 {
+		t.Fatalf("expected 1 record, got %v", found)
+	}
+	_ = db.Close()
+}
@@ -1,52 +1,161 @@
 package gobble
 
 import (
+	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"os"
-	"strings"
+	"strconv"
+	"sync"
 )
 
 // Storage Structure:
 // - DB directory
+//   - lock file: ".lock"
+//   - write-ahead log: ".wal"
 //   - Collection1 directory
-//     - numbered files each containing a gob encoded struct: "d1.gob" "d2.gob" ...
 //     - metadata file: "meta.gob"
+//     - with FileStorage (the default): numbered files each containing a gob encoded struct: "d1.gob" "d2.gob" ...
+//     - with SegmentStorage: numbered append-only segment files each containing many records: "s1.seg" "s2.seg" ...
+//     - indices, saved at checkpoints and when the DB is closed: "index-<name>.gob"
+//     - while a file is being written: a temporary file ".tmp-*", renamed over the file once complete
 
 type DB struct {
 	Path string
+
+	state *dbState
+}
+
+// dbState is shared by all copies of a DB returned from one OpenDB call
+type dbState struct {
+	opts     options
+	lockFile *os.File
+	wal      *writeAheadLog // nil when opened read-only
+	txMu     sync.Mutex     // held by the running transaction
+
+	// Checkpoints of the log ask for the changed indices to be saved, which saveIndicesLoop does once their collections
+	// are free, as the write that triggered a checkpoint still holds the lock of its collection
+	indexSaves     chan struct{}
+	stopIndexSaves chan struct{}
+	indexSavesDone chan struct{}
+	stopOnce       sync.Once
+
+	mu          sync.Mutex
+	collections map[string]*collectionState
 }
 
+// collectionState is shared by all Collection values opened for the same collection of a DB
+type collectionState struct {
+	mu       sync.RWMutex // held exclusively by writes, shared by reads
+	store    storage
+	readOnly bool
+
+	// Open indices of the collection by name, each an indexer[T] for the T the collection was opened with
+	indices map[string]registeredIndex
+	// Open references to the collection's records by child collection and name, like "orders.customer"
+	refs map[string]reference
+}
+
+// Collection is safe for concurrent use by multiple goroutines: reads run in parallel, writes one at a time.
 type Collection[T any] struct {
-	Name    string
-	DB      DB
-	Indices []Index[T, any] // Go doesn't seem to support generics here, this is internal so `any` is fine
+	Name string
+	DB   DB
+
+	state *collectionState
 }
 
+// Index is safe for concurrent use like its collection, but Index must not be accessed directly while other goroutines
+// use the collection.
 type Index[T any, D comparable] struct {
 	Collection *Collection[T]
+	Name       string
 	Index      map[D][]string
 	Extractor  func(T) D
+
+	unique bool
+	indexFile
 }
 
 type Query[T any] func(T) bool
 type Updater[T any] func(T) T
 
 type CollectionMetadata[T any] struct {
-	LastID int
+	LastID  int
+	Storage Storage
+	Version uint64 // counts writes, so persisted indices can tell whether they are up to date
 }
 
-func OpenDB(path string) (DB, error) {
+// ErrNotFound is returned by the ID based functions when no record has the given ID.
+var ErrNotFound = errors.New("record not found")
+
+// OpenDB opens the DB in the directory at path, creating it if it doesn't exist.
+// The directory is locked until Close is called, by default exclusively, so opening it again (from this or another
+// process) fails with ErrLocked. With WithLockMode(LockShared) any number of read-only opens can share it.
+func OpenDB(path string, opts ...Option) (DB, error) {
 	if err := os.MkdirAll(path, 0755); err != nil {
 		return DB{}, err
 	}
-	return DB{Path: path}, nil
+
+	state := &dbState{opts: defaultOptions(), collections: map[string]*collectionState{}}
+	for _, opt := range opts {
+		opt(&state.opts)
+	}
+
+	lockFile, err := acquireLock(path, state.opts.lockMode)
+	if err != nil {
+		return DB{}, err
+	}
+	state.lockFile = lockFile
+
+	// Replay the writes the last process logged but maybe didn't get to apply. Readers can't, they only see the records
+	// that had been written.
+	if state.opts.lockMode != LockShared {
+		if err := removeTempFiles(path); err != nil {
+			_ = lockFile.Close()
+			return DB{}, err
+		}
+
+		state.wal, err = openWAL(path, state.opts, state.syncStores)
+		if err != nil {
+			_ = lockFile.Close()
+			return DB{}, err
+		}
+
+		state.indexSaves = make(chan struct{}, 1)
+		state.stopIndexSaves = make(chan struct{})
+		state.indexSavesDone = make(chan struct{})
+		go state.saveIndicesLoop()
+	}
+
+	return DB{Path: path, state: state}, nil
 }
 
-func OpenCollection[T any](db DB, name string) (Collection[T], error) {
+// OpenCollection opens the collection called name, creating it if it doesn't exist.
+// Options passed here override the ones passed to OpenDB. The storage engine of an existing collection can't be changed,
+// so WithStorage only has an effect when the collection is created.
+func OpenCollection[T any](db DB, name string, opts ...Option) (Collection[T], error) {
 	if !isValidCollectionName(name) {
 		return Collection[T]{}, fmt.Errorf("invalid collection name")
 	}
+	if db.state == nil {
+		return Collection[T]{}, fmt.Errorf("db was not opened with OpenDB")
+	}
+
+	db.state.mu.Lock()
+	defer db.state.mu.Unlock()
+
+	if state, ok := db.state.collections[name]; ok {
+		return Collection[T]{Name: name, DB: db, state: state}, nil
+	}
+
+	o := db.state.opts
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// The lock is taken by OpenDB, so its mode can't be changed per collection
+	o.lockMode = db.state.opts.lockMode
+	readOnly := o.lockMode == LockShared
 
 	exists, err := db.CollectionExists(name)
 	if err != nil {
@@ -54,38 +163,37 @@ func OpenCollection[T any](db DB, name string) (Collection[T], error) {
 	}
 
 	if !exists {
-		if err := initializeCollection[T](name, db); err != nil {
+		if readOnly {
+			return Collection[T]{}, ErrReadOnly
+		}
+		if err := initializeCollection[T](name, db, o.storage); err != nil {
 			return Collection[T]{}, err
 		}
 	}
 
-	return Collection[T]{Name: name, DB: db}, nil
-}
-
-func OpenIndex[T any, D comparable](c *Collection[T], extractor func(T) D) (Index[T, any], error) {
-	index, err := buildIndex(c, extractor)
-	if err != nil {
-		return Index[T, any]{}, err
+	// Leftovers from writes interrupted by a crash, the files they were replacing are still intact
+	if !readOnly {
+		if err := removeTempFiles(db.Path + "/" + name); err != nil {
+			return Collection[T]{}, err
+		}
 	}
 
-	x := func(a T) any {
-		return extractor(a)
+	store, err := openStorage(db.Path+"/"+name, o)
+	if err != nil {
+		return Collection[T]{}, err
 	}
-	y := map[any][]string{}
-
-	for k, v := range index {
-		y[k] = make([]string, len(v))
-		for i, id := range v {
-			y[k][i] = id
-		}
+	state := &collectionState{
+		store:    store,
+		readOnly: readOnly,
+		indices:  map[string]registeredIndex{},
+		refs:     map[string]reference{},
 	}
+	db.state.collections[name] = state
 
-	indexInterface := Index[T, any]{Index: y, Extractor: x, Collection: c}
-	c.Indices = append(c.Indices, indexInterface)
-	return indexInterface, nil
+	return Collection[T]{Name: name, DB: db, state: state}, nil
 }
 
-func initializeCollection[T any](name string, db DB) error {
+func initializeCollection[T any](name string, db DB, storage Storage) error {
 	exists, err := db.CollectionExists(name)
 	if err != nil {
 		return err
@@ -98,68 +206,27 @@ func initializeCollection[T any](name string, db DB) error {
 		return err
 	}
 
-	file, err := os.Create(db.Path + "/" + name + "/meta.gob")
-	if err != nil {
-		return err
-	}
-	defer func(file *os.File) {
-		_ = file.Close()
-	}(file)
-
-	enc := gob.NewEncoder(file)
-	err = enc.Encode(CollectionMetadata[T]{LastID: 0})
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return writeGobAtomic(db.Path+"/"+name+"/meta.gob", CollectionMetadata[T]{LastID: 0, Storage: storage})
 }
 
-func buildIndex[T any, D comparable](c *Collection[T], extractor func(T) D) (map[D][]string, error) {
-	// Open the directory of the collection
-	dir, err := os.Open(c.DB.Path + "/" + c.Name)
-	if err != nil {
-		return nil, err
-	}
-	defer func(dir *os.File) {
-		err := dir.Close()
-		if err != nil {
-			fmt.Println(err)
-		}
-	}(dir)
-
-	files, err := dir.Readdir(-1)
+// buildMultiIndex builds an index where every record is listed under each of the keys returned for it
+func buildMultiIndex[T any, D comparable](c *Collection[T], keys func(T) []D) (map[D][]string, error) {
+	ids, err := c.state.store.ids()
 	if err != nil {
 		return nil, err
 	}
 
 	index := make(map[D][]string)
 
-	for _, file := range files {
-		if file.IsDir() || file.Name()[0] != 'd' {
-			continue
-		}
-
-		f, err := os.Open(c.DB.Path + "/" + c.Name + "/" + file.Name())
+	for _, id := range ids {
+		data, err := c.read(id)
 		if err != nil {
 			return nil, err
 		}
 
-		var data T
-		dec := gob.NewDecoder(f)
-		err = dec.Decode(&data)
-		if err != nil {
-			return nil, err
-		}
-		err = f.Close()
-		if err != nil {
-			return nil, err
+		for _, key := range keys(data) {
+			index[key] = append(index[key], strconv.Itoa(id))
 		}
-
-		key := extractor(data)
-
-		fileID := file.Name()[1 : len(file.Name())-4]
-		index[key] = append(index[key], fileID)
 	}
 
 	return index, nil
@@ -174,11 +241,18 @@ func (t *DB) ListCollections() ([]string, error) {
 		_ = f.Close()
 	}(f)
 
-	names, err := f.Readdirnames(-1)
+	files, err := f.Readdir(-1)
 	if err != nil {
 		return nil, err
 	}
 
+	var names []string
+	for _, file := range files {
+		if file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+
 	return names, nil
 }
 
@@ -207,6 +281,38 @@ func (t *DB) DeleteCollection(name string) error {
 		return fmt.Errorf("collection does not exist")
 	}
 
+	if t.state != nil && t.state.opts.lockMode == LockShared {
+		return ErrReadOnly
+	}
+
+	if t.state != nil {
+		// So the log holds no entries for the collection when it's gone
+		if err := t.state.wal.checkpoint(); err != nil {
+			return err
+		}
+
+		t.state.mu.Lock()
+		state, ok := t.state.collections[name]
+		delete(t.state.collections, name)
+		t.state.mu.Unlock()
+
+		// References from the collection's records go with it
+		t.state.dropReferences(func(ref reference) bool {
+			return ref.childState() == state
+		})
+
+		if ok {
+			state.mu.Lock()
+			err := state.store.close()
+			// So a save after a checkpoint doesn't write them back into the collection's directory
+			state.indices = map[string]registeredIndex{}
+			state.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	if err = os.RemoveAll(t.Path + "/" + name); err != nil {
 		return err
 	}
@@ -214,345 +320,498 @@ func (t *DB) DeleteCollection(name string) error {
 	return nil
 }
 
-func (t *Collection[T]) getMetadata() (CollectionMetadata[T], error) {
-	file, err := os.Open(t.DB.Path + "/" + t.Name + "/meta.gob")
+// Close releases the files held open by the collections of the DB, and the lock on its directory.
+// Collections opened from it can't be used afterwards.
+func (t *DB) Close() error {
+	if t.state == nil {
+		return nil
+	}
+
+	// The log stays, closed, so writes through collections opened from the DB fail instead of finding no log
+	var firstErr error
+	if t.state.wal != nil {
+		firstErr = t.state.wal.close()
+
+		t.state.stopOnce.Do(func() {
+			close(t.state.stopIndexSaves)
+			<-t.state.indexSavesDone
+		})
+	}
+
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+
+	for name, state := range t.state.collections {
+		state.mu.Lock()
+		if err := state.saveIndices(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := state.store.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		state.mu.Unlock()
+		delete(t.state.collections, name)
+	}
+
+	if t.state.lockFile != nil {
+		// Closing the file releases the lock
+		if err := t.state.lockFile.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		t.state.lockFile = nil
+	}
+
+	return firstErr
+}
+
+// syncStores syncs the storage of every open collection, for checkpoints of the write-ahead log
+func (t *dbState) syncStores() error {
+	t.mu.Lock()
+	stores := make([]storage, 0, len(t.collections))
+	for _, state := range t.collections {
+		stores = append(stores, state.store)
+	}
+	t.mu.Unlock()
+
+	for _, store := range stores {
+		if err := store.sync(); err != nil {
+			return err
+		}
+	}
+
+	if t.indexSaves != nil {
+		select {
+		case t.indexSaves <- struct{}{}:
+		default:
+			// A save is already due
+		}
+	}
+
+	return nil
+}
+
+// saveIndicesLoop saves the changed indices of every open collection when a checkpoint asks for it, until Close. A save
+// that fails is left to the next checkpoint, or to Close, which returns the error.
+func (t *dbState) saveIndicesLoop() {
+	defer close(t.indexSavesDone)
+
+	for {
+		select {
+		case <-t.stopIndexSaves:
+			return
+		case <-t.indexSaves:
+			t.mu.Lock()
+			states := make([]*collectionState, 0, len(t.collections))
+			for _, state := range t.collections {
+				states = append(states, state)
+			}
+			t.mu.Unlock()
+
+			for _, state := range states {
+				state.mu.Lock()
+				_ = state.saveIndices()
+				state.mu.Unlock()
+			}
+		}
+	}
+}
+
+// read decodes the record with the given ID, t.state.mu must be held
+func (t *Collection[T]) read(id int) (T, error) {
+	var data T
+
+	b, err := t.state.store.get(id)
 	if err != nil {
-		return CollectionMetadata[T]{}, err
+		return data, err
 	}
-	defer func(file *os.File) {
-		_ = file.Close()
-	}(file)
 
-	var meta CollectionMetadata[T]
-	dec := gob.NewDecoder(file)
-	err = dec.Decode(&meta)
+	dec := gob.NewDecoder(bytes.NewReader(b))
+	err = dec.Decode(&data)
 	if err != nil {
-		return CollectionMetadata[T]{}, err
+		return data, err
 	}
 
-	return meta, nil
+	return data, nil
 }
 
-func (t *Collection[T]) incrementID() error {
-	meta, err := t.getMetadata()
+// readFileID reads the record with an ID as kept by indices
+func (t *Collection[T]) readFileID(fileID string) (T, error) {
+	id, err := strconv.Atoi(fileID)
 	if err != nil {
-		return err
+		var data T
+		return data, err
 	}
 
-	meta.LastID++
+	return t.read(id)
+}
 
-	file, err := os.Create(t.DB.Path + "/" + t.Name + "/meta.gob")
-	if err != nil {
-		return err
+func (t *Collection[T]) readFileIDs(fileIDs []string) ([]T, error) {
+	var results []T
+
+	for _, fileID := range fileIDs {
+		data, err := t.readFileID(fileID)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, data)
 	}
-	defer func(file *os.File) {
-		_ = file.Close()
-	}(file)
 
-	enc := gob.NewEncoder(file)
-	err = enc.Encode(meta)
-	if err != nil {
+	return results, nil
+}
+
+// write encodes data as the record with the given ID, t.state.mu must be held exclusively
+func (t *Collection[T]) write(id int, data T) error {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(data); err != nil {
 		return err
 	}
 
-	return nil
+	return t.put(id, buf.Bytes())
+}
+
+// put logs the record to the write-ahead log and then stores it, t.state.mu must be held exclusively
+func (t *Collection[T]) put(id int, data []byte) error {
+	entry := walEntry{Collection: t.Name, ID: id, Data: data}
+	return t.DB.state.wal.log([]walEntry{entry}, false, func() error {
+		return t.state.store.put(id, data)
+	})
+}
+
+// remove logs the removal to the write-ahead log and then removes the record, t.state.mu must be held exclusively
+func (t *Collection[T]) remove(id int) error {
+	entry := walEntry{Collection: t.Name, ID: id, Deleted: true}
+	return t.DB.state.wal.log([]walEntry{entry}, false, func() error {
+		return t.state.store.remove(id)
+	})
 }
 
 func (t *Collection[T]) Insert(data T) error {
-	if err := t.incrementID(); err != nil {
-		return err
+	_, err := t.InsertWithID(data)
+	return err
+}
+
+// InsertWithID inserts data like Insert, and returns the ID allocated to the new record.
+// The ID stays the same for the lifetime of the record, and can be used with GetByID, ReplaceByID and DeleteByID.
+func (t *Collection[T]) InsertWithID(data T) (int, error) {
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+
+	if t.state.readOnly {
+		return 0, ErrReadOnly
 	}
 
-	meta, err := t.getMetadata()
-	if err != nil {
-		return err
+	return t.insert(data)
+}
+
+// insert inserts data as a new record and returns its ID, t.state.mu must be held exclusively
+func (t *Collection[T]) insert(data T) (int, error) {
+	if err := t.checkConstraints([]recordChange[T]{{data: data}}); err != nil {
+		return 0, err
 	}
 
-	file, err := os.Create(t.DB.Path + "/" + t.Name + "/d" + fmt.Sprintf("%d", meta.LastID) + ".gob")
+	id, err := t.state.store.nextID()
+	if err != nil {
+		return 0, err
+	}
 
+	err = t.write(id, data)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	t.addToIndices(strconv.Itoa(id), data)
+
+	return id, nil
+}
+
+// GetByID returns the record with the given ID, or ErrNotFound if there is none.
+func (t *Collection[T]) GetByID(id int) (T, error) {
+	t.state.mu.RLock()
+	defer t.state.mu.RUnlock()
+
+	return t.read(id)
+}
+
+// ReplaceByID overwrites the record with the given ID with data, or returns ErrNotFound if there is none.
+func (t *Collection[T]) ReplaceByID(id int, data T) error {
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+
+	if t.state.readOnly {
+		return ErrReadOnly
 	}
-	defer func(file *os.File) {
-		_ = file.Close()
-	}(file)
 
-	enc := gob.NewEncoder(file)
-	err = enc.Encode(data)
+	old, err := t.read(id)
 	if err != nil {
 		return err
 	}
 
-	for _, indexInterface := range t.Indices {
-		index := indexInterface
-		key := index.Extractor(data)
-		index.Index[key] = append(index.Index[key], fmt.Sprintf("%d", meta.LastID))
+	return t.update([]recordChange[T]{{fileID: strconv.Itoa(id), old: &old, data: data}})
+}
+
+// DeleteByID deletes the record with the given ID, or returns ErrNotFound if there is none.
+// The records referring to it are handled as their references say, see OpenReference.
+func (t *Collection[T]) DeleteByID(id int) error {
+	if t.referenced() {
+		return t.deleteReferenced(func() ([]string, error) {
+			return []string{strconv.Itoa(id)}, nil
+		})
 	}
 
-	return nil
-}
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
 
-func (t *Collection[T]) Modify(query Query[T], updater Updater[T]) error {
-	dir, err := os.Open(t.DB.Path + "/" + t.Name)
+	if t.state.readOnly {
+		return ErrReadOnly
+	}
+
+	data, err := t.read(id)
 	if err != nil {
 		return err
 	}
-	defer func(dir *os.File) {
-		err := dir.Close()
-		if err != nil {
-			fmt.Println(err)
-		}
-	}(dir)
 
-	files, err := dir.Readdir(-1)
+	err = t.remove(id)
 	if err != nil {
 		return err
 	}
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
+	t.removeFromIndices(strconv.Itoa(id), data)
 
-		if file.Name()[0] != 'd' {
-			continue
-		}
+	return nil
+}
 
-		f, err := os.Open(t.DB.Path + "/" + t.Name + "/" + file.Name())
+// deleteFileIDs deletes the records with the given IDs as kept by indices, t.state.mu must be held exclusively
+func (t *Collection[T]) deleteFileIDs(fileIDs []string) error {
+	for _, fileID := range fileIDs {
+		id, err := strconv.Atoi(fileID)
 		if err != nil {
 			return err
 		}
 
-		var data T
-		dec := gob.NewDecoder(f)
-		err = dec.Decode(&data)
-		err = f.Close()
+		data, err := t.read(id)
 		if err != nil {
 			return err
 		}
+
+		// Remove from indices
+		t.removeFromIndices(fileID, data)
+
+		err = t.remove(id)
 		if err != nil {
 			return err
 		}
+	}
 
-		if query(data) {
-			// Remove the old data from the indices
-			for _, index := range t.Indices {
-				key := index.Extractor(data)
-				fileIDs := index.Index[key]
-				for i, id := range fileIDs {
-					if id == file.Name()[1:len(file.Name())-4] {
-						index.Index[key] = append(fileIDs[:i], fileIDs[i+1:]...)
-						break
-					}
-				}
-			}
-
-			data = updater(data)
-
-			f, err := os.Create(t.DB.Path + "/" + t.Name + "/" + file.Name())
-			if err != nil {
-				return err
-			}
-
-			enc := gob.NewEncoder(f)
-			err = enc.Encode(data)
-			err = f.Close()
-			if err != nil {
-				return err
-			}
-			if err != nil {
-				return err
-			}
+	return nil
+}
 
-			// Add the updated data to the indices
-			for _, index := range t.Indices {
-				key := index.Extractor(data)
-				index.Index[key] = append(index.Index[key], file.Name()[1:len(file.Name())-4])
-			}
+func (t *Collection[T]) addToIndices(fileID string, data T) {
+	for _, registered := range t.state.indices {
+		if index, ok := registered.(indexer[T]); ok {
+			index.add(fileID, data)
 		}
 	}
+}
 
-	return nil
+func (t *Collection[T]) removeFromIndices(fileID string, data T) {
+	for _, registered := range t.state.indices {
+		if index, ok := registered.(indexer[T]); ok {
+			index.remove(fileID, data)
+		}
+	}
 }
 
-func (t *Collection[T]) Delete(query Query[T]) error {
-	dir, err := os.Open(t.DB.Path + "/" + t.Name)
-	if err != nil {
+// update checks the changes of existing records against the constraints of the indices, and if none is broken writes
+// them all, t.state.mu must be held exclusively
+func (t *Collection[T]) update(changes []recordChange[T]) error {
+	if err := t.checkConstraints(changes); err != nil {
 		return err
 	}
-	defer func(dir *os.File) {
-		err := dir.Close()
+
+	for _, change := range changes {
+		id, err := strconv.Atoi(change.fileID)
 		if err != nil {
-			fmt.Println(err)
+			return err
 		}
-	}(dir)
 
-	files, err := dir.Readdir(-1)
-	if err != nil {
-		return err
-	}
+		// Remove the old data from the indices
+		t.removeFromIndices(change.fileID, *change.old)
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
+		err = t.write(id, change.data)
+		if err != nil {
+			return err
 		}
 
-		if file.Name()[0] != 'd' {
-			continue
-		}
+		// Add the updated data to the indices
+		t.addToIndices(change.fileID, change.data)
+	}
+
+	return nil
+}
 
-		f, err := os.Open(t.DB.Path + "/" + t.Name + "/" + file.Name())
+// modifyFileIDs updates the records with the given IDs as kept by indices, t.state.mu must be held exclusively
+func (t *Collection[T]) modifyFileIDs(fileIDs []string, updater Updater[T]) error {
+	changes := make([]recordChange[T], 0, len(fileIDs))
+	for _, fileID := range fileIDs {
+		data, err := t.readFileID(fileID)
 		if err != nil {
 			return err
 		}
 
-		var data T
-		dec := gob.NewDecoder(f)
-		err = dec.Decode(&data)
-		err = f.Close()
+		old := data
+		changes = append(changes, recordChange[T]{fileID: fileID, old: &old, data: updater(data)})
+	}
+
+	return t.update(changes)
+}
+
+// Modify replaces every record query returns true for with what updater returns for it. If that breaks a unique
+// index, no record is modified.
+func (t *Collection[T]) Modify(query Query[T], updater Updater[T]) error {
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+
+	if t.state.readOnly {
+		return ErrReadOnly
+	}
+
+	ids, err := t.state.store.ids()
+	if err != nil {
+		return err
+	}
+
+	var changes []recordChange[T]
+	for _, id := range ids {
+		data, err := t.read(id)
 		if err != nil {
 			return err
 		}
+
+		if query(data) {
+			old := data
+			changes = append(changes, recordChange[T]{fileID: strconv.Itoa(id), old: &old, data: updater(data)})
+		}
+	}
+
+	return t.update(changes)
+}
+
+// Delete deletes every record query returns true for. The records referring to them are handled as their references
+// say, and if one is refused with Restrict, no record is deleted.
+func (t *Collection[T]) Delete(query Query[T]) error {
+	if t.referenced() {
+		return t.DB.update(func(tx *Tx) error {
+			return t.Tx(tx).Delete(query)
+		}, false)
+	}
+
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+
+	if t.state.readOnly {
+		return ErrReadOnly
+	}
+
+	ids, err := t.state.store.ids()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		data, err := t.read(id)
 		if err != nil {
 			return err
 		}
 
 		if query(data) {
-			err = os.Remove(t.DB.Path + "/" + t.Name + "/" + file.Name())
+			err = t.remove(id)
 			if err != nil {
 				return err
 			}
 
 			// Modify indices
-			for _, index := range t.Indices {
-				key := index.Extractor(data)
-				fileIDs := index.Index[key]
-				for i, id := range fileIDs {
-					if id == file.Name()[1:len(file.Name())-4] {
-						index.Index[key] = append(fileIDs[:i], fileIDs[i+1:]...)
-						break
-					}
-				}
-			}
+			t.removeFromIndices(strconv.Itoa(id), data)
 		}
 	}
 
 	return nil
 }
 
-func (t *Collection[T]) Select(query Query[T]) ([]T, error) {
-	dir, err := os.Open(t.DB.Path + "/" + t.Name)
+// Select returns the records query returns true for, in ID order unless sorted with SortBy or SortByKey.
+// With Limit (and no sorting) it stops reading records once it has enough, with sorting it only keeps the ones that
+// can still make it into the result.
+func (t *Collection[T]) Select(query Query[T], opts ...SelectOption) ([]T, error) {
+	t.state.mu.RLock()
+	defer t.state.mu.RUnlock()
+
+	s, err := newSelection[T](opts)
 	if err != nil {
 		return nil, err
 	}
-	defer func(dir *os.File) {
-		err := dir.Close()
-		if err != nil {
-			fmt.Println(err)
-		}
-	}(dir)
 
-	files, err := dir.Readdir(-1)
+	ids, err := t.state.store.ids()
 	if err != nil {
 		return nil, err
 	}
 
-	var results []T
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-
-		if file.Name()[0] != 'd' {
-			continue
-		}
-
-		f, err := os.Open(t.DB.Path + "/" + t.Name + "/" + file.Name())
+	for _, id := range ids {
+		data, err := t.read(id)
 		if err != nil {
 			return nil, err
 		}
 
-		var data T
-		dec := gob.NewDecoder(f)
-		err = dec.Decode(&data)
-		err = f.Close()
-		if err != nil {
-			return nil, err
-		}
-		if err != nil {
-			return nil, err
-		}
-
-		if query(data) {
-			results = append(results, data)
+		if query(data) && !s.add(data) {
+			break
 		}
 	}
 
-	return results, nil
+	return s.get(), nil
 }
 
 func (t *Collection[T]) Number() (int, error) {
-	dir, err := os.Open(t.DB.Path + "/" + t.Name)
-	if err != nil {
-		return 0, err
-	}
-	defer func(dir *os.File) {
-		err := dir.Close()
-		if err != nil {
-			fmt.Println(err)
-		}
-	}(dir)
-
-	files, err := dir.Readdir(-1)
-	if err != nil {
-		return 0, err
-	}
-
-	count := 0
-	for _, file := range files {
-		if !file.IsDir() && strings.HasPrefix(file.Name(), "d") {
-			count++
-		}
-	}
+	t.state.mu.RLock()
+	defer t.state.mu.RUnlock()
 
-	return count, nil
+	return t.state.store.count()
 }
 
 func (t *Index[T, D]) Get(key D) ([]T, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
 	fileIDs, ok := t.Index[key]
 	if !ok {
 		// If the key does not exist, return an empty slice and no error
 		return []T{}, nil
 	}
 
-	var results []T
-
-	for _, fileID := range fileIDs {
-		f, err := os.Open(t.Collection.DB.Path + "/" + t.Collection.Name + "/d" + fileID + ".gob")
-		if err != nil {
-			return nil, err
-		}
+	return t.Collection.readFileIDs(fileIDs)
+}
 
-		var data T
-		dec := gob.NewDecoder(f)
-		err = dec.Decode(&data)
-		if err != nil {
-			_ = f.Close()
-			return nil, err
-		}
+func (t *Index[T, D]) Del(key D) error {
+	if t.Collection.referenced() {
+		return t.Collection.deleteReferenced(func() ([]string, error) {
+			return t.Index[key], t.check()
+		})
+	}
 
-		err = f.Close()
-		if err != nil {
-			return nil, err
-		}
+	t.Collection.state.mu.Lock()
+	defer t.Collection.state.mu.Unlock()
 
-		results = append(results, data)
+	if err := t.check(); err != nil {
+		return err
 	}
 
-	return results, nil
-}
+	if t.Collection.state.readOnly {
+		return ErrReadOnly
+	}
 
-func (t *Index[T, D]) Del(key D) error {
 	fileIDs, ok := t.Index[key]
 	if !ok {
 		return nil
@@ -561,10 +820,15 @@ func (t *Index[T, D]) Del(key D) error {
 	fileIDsCopy := make([]string, len(fileIDs))
 	copy(fileIDsCopy, fileIDs)
 
-	if len(t.Collection.Indices) == 1 {
+	if len(t.Collection.state.indices) == 1 {
 		// only an optimization
 		for _, fileID := range fileIDsCopy {
-			err := os.Remove(t.Collection.DB.Path + "/" + t.Collection.Name + "/d" + fileID + ".gob")
+			id, err := strconv.Atoi(fileID)
+			if err != nil {
+				return err
+			}
+
+			err = t.Collection.remove(id)
 			if err != nil {
 				return err
 			}
@@ -574,41 +838,8 @@ func (t *Index[T, D]) Del(key D) error {
 		return nil
 	}
 
-	for _, fileID := range fileIDsCopy {
-		f, err := os.Open(t.Collection.DB.Path + "/" + t.Collection.Name + "/d" + fileID + ".gob")
-		if err != nil {
-			return err
-		}
-
-		var data T
-		dec := gob.NewDecoder(f)
-		err = dec.Decode(&data)
-		if err != nil {
-			_ = f.Close()
-			return err
-		}
-
-		err = f.Close()
-		if err != nil {
-			return err
-		}
-
-		// Remove from indices
-		for _, index := range t.Collection.Indices {
-			indexKey := index.Extractor(data)
-			indexFileIDs := index.Index[indexKey]
-			for i, id := range indexFileIDs {
-				if id == fileID {
-					index.Index[indexKey] = append(indexFileIDs[:i], indexFileIDs[i+1:]...)
-					break
-				}
-			}
-		}
-
-		err = os.Remove(t.Collection.DB.Path + "/" + t.Collection.Name + "/d" + fileID + ".gob")
-		if err != nil {
-			return err
-		}
+	if err := t.Collection.deleteFileIDs(fileIDsCopy); err != nil {
+		return err
 	}
 
 	delete(t.Index, key)
@@ -617,75 +848,64 @@ func (t *Index[T, D]) Del(key D) error {
 }
 
 func (t *Index[T, D]) Mod(key D, updater Updater[T]) error {
+	t.Collection.state.mu.Lock()
+	defer t.Collection.state.mu.Unlock()
+
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	if t.Collection.state.readOnly {
+		return ErrReadOnly
+	}
+
 	fileIDs, ok := t.Index[key]
 	if !ok {
 		return nil
 	}
 
-	fileIDsCopy := make([]string, len(fileIDs))
-	copy(fileIDsCopy, fileIDs)
-
-	for _, fileID := range fileIDsCopy {
-		f, err := os.Open(t.Collection.DB.Path + "/" + t.Collection.Name + "/d" + fileID + ".gob")
-		if err != nil {
-			return err
-		}
-
-		var data T
-		dec := gob.NewDecoder(f)
-		err = dec.Decode(&data)
-		if err != nil {
-			_ = f.Close()
-			return err
-		}
-
-		err = f.Close()
-		if err != nil {
-			return err
-		}
+	return t.Collection.modifyFileIDs(fileIDs, updater)
+}
 
-		// Remove the data from the indices
-		for _, index := range t.Collection.Indices {
-			indexKey := index.Extractor(data)
-			indexFileIDs := index.Index[indexKey]
-			for i, id := range indexFileIDs {
-				if id == fileID {
-					index.Index[indexKey] = append(indexFileIDs[:i], indexFileIDs[i+1:]...)
-					break
-				}
-			}
-		}
+// Upsert updates the records with key like Mod, or if there are none, inserts the record insert returns, which must
+// have key. It returns whether it inserted. No other write can come between looking for key and the update or insert,
+// so with a unique index, concurrent Upserts with the same key never insert more than one record.
+func (t *Index[T, D]) Upsert(key D, insert func() T, updater Updater[T]) (bool, error) {
+	t.Collection.state.mu.Lock()
+	defer t.Collection.state.mu.Unlock()
 
-		data = updater(data)
+	if err := t.check(); err != nil {
+		return false, err
+	}
 
-		f, err = os.Create(t.Collection.DB.Path + "/" + t.Collection.Name + "/d" + fileID + ".gob")
-		if err != nil {
-			return err
-		}
+	if t.Collection.state.readOnly {
+		return false, ErrReadOnly
+	}
 
-		enc := gob.NewEncoder(f)
-		err = enc.Encode(data)
-		if err != nil {
-			_ = f.Close()
-			return err
-		}
+	if fileIDs, ok := t.Index[key]; ok {
+		return false, t.Collection.modifyFileIDs(fileIDs, updater)
+	}
 
-		err = f.Close()
-		if err != nil {
-			return err
-		}
+	data := insert()
+	if t.Extractor(data) != key {
+		return false, fmt.Errorf("record to insert doesn't have the key %v", key)
+	}
 
-		// Add the updated data to the indices
-		for _, index := range t.Collection.Indices {
-			indexKey := index.Extractor(data)
-			index.Index[indexKey] = append(index.Index[indexKey], fileID)
-		}
+	if _, err := t.Collection.insert(data); err != nil {
+		return false, err
 	}
 
-	return nil
+	return true, nil
 }
 
 func (t *Index[T, D]) Num(key D) (int, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return 0, err
+	}
+
 	fileIDs, ok := t.Index[key]
 	if !ok {
 		return 0, nil
@@ -1,6 +1,9 @@
 package gobble
 
 import (
+	"bytes"
+	"encoding/gob"
+	"errors"
 	"fmt"
 	"os"
 	"testing"
@@ -50,10 +53,10 @@ func sortPersonsByName(ps []ExamplePersonStruct) []ExamplePersonStruct {
 func Test(t *testing.T) {
 	db, _ := OpenDB("testdb")
 	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
-	i1, _ := OpenIndex[ExamplePersonStruct, string](&c, func(p ExamplePersonStruct) string {
+	i1, _ := OpenIndex[ExamplePersonStruct, string](&c, "name", func(p ExamplePersonStruct) string {
 		return p.Name
 	})
-	i2, _ := OpenIndex[ExamplePersonStruct, int](&c, func(p ExamplePersonStruct) int {
+	i2, _ := OpenIndex[ExamplePersonStruct, int](&c, "age", func(p ExamplePersonStruct) int {
 		return p.Age
 	})
 
@@ -126,3 +129,84 @@ func Test(t *testing.T) {
 	fmt.Println(x)
 	_ = os.RemoveAll("testdb")
 }
+
+func TestByID(t *testing.T) {
+	db, _ := OpenDB("testdb-id")
+	defer func() { _ = os.RemoveAll("testdb-id") }()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	i1, _ := OpenIndex[ExamplePersonStruct, string](&c, "name", func(p ExamplePersonStruct) string {
+		return p.Name
+	})
+
+	id1, err := c.InsertWithID(ExamplePersonStruct{Name: "ExamplePersonStruct 1", Age: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, _ := c.InsertWithID(ExamplePersonStruct{Name: "ExamplePersonStruct 2", Age: 2})
+	if id1 == id2 {
+		t.Fatalf("expected distinct IDs, got %d twice", id1)
+	}
+
+	p, err := c.GetByID(id2)
+	if err != nil || p != (ExamplePersonStruct{Name: "ExamplePersonStruct 2", Age: 2}) {
+		t.Fatalf("GetByID returned %v, %v", p, err)
+	}
+
+	if err := c.ReplaceByID(id1, ExamplePersonStruct{Name: "ExamplePersonStruct 1 2", Age: 10}); err != nil {
+		t.Fatal(err)
+	}
+	x, _ := i1.Get("ExamplePersonStruct 1")
+	y, _ := i1.Get("ExamplePersonStruct 1 2")
+	if !verifyItemsEqual(x, []ExamplePersonStruct{}) || !verifyItemsEqual(y, []ExamplePersonStruct{{Name: "ExamplePersonStruct 1 2", Age: 10}}) {
+		t.Fatal("index not updated by ReplaceByID")
+	}
+
+	if err := c.DeleteByID(id2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetByID(id2); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := c.DeleteByID(id2); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if n, _ := i1.Num("ExamplePersonStruct 2"); n != 0 {
+		t.Fatal("index not updated by DeleteByID")
+	}
+}
+
+func TestTempFilesIgnored(t *testing.T) {
+	db, _ := OpenDB("testdb-tmp")
+	defer func() { _ = os.RemoveAll("testdb-tmp") }()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	_ = c.Insert(ExamplePersonStruct{Name: "ExamplePersonStruct 1", Age: 1})
+
+	// What an interrupted write leaves behind
+	stray := "testdb-tmp/testcollection/" + tempFilePrefix + "123"
+	if err := os.WriteFile(stray, []byte{0x01, 0x02}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := c.Select(func(p ExamplePersonStruct) bool { return true })
+	if err != nil || !verifyItemsEqual(x, []ExamplePersonStruct{{Name: "ExamplePersonStruct 1", Age: 1}}) {
+		t.Fatalf("Select returned %v, %v", x, err)
+	}
+
+	_ = db.Close()
+	db, _ = OpenDB("testdb-tmp")
+	c, _ = OpenCollection[ExamplePersonStruct](db, "testcollection")
+	if _, err := os.Stat(stray); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("temp file not cleaned up on OpenCollection")
+	}
+	if n, _ := c.Number(); n != 1 {
+		t.Fatalf("expected 1 record, got %d", n)
+	}
+}
+
+func encodeForTest(t *testing.T, v any) []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,411 @@
+package gobble
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Indices
+//
+// The indices of a collection are registered by name in the state shared by all Collection values for it, so every
+// write updates all of them whichever Collection value it goes through. The registry only knows them as indexer[T],
+// which lets indices with different key types (and kinds of index) live side by side.
+//
+// An index is saved to index-<name>.gob in the collection's directory when the DB is closed, and after checkpoints of
+// the write-ahead log if it changed, together with the collection's write version at that point and the kind of index.
+// Every put and remove of a record changes the version, so when the index is opened again it is only loaded if the
+// version still matches and it is the same kind of index, otherwise (the collection was written to without the index
+// open or since the last save, or the name was used by another kind of index) it is rebuilt from the records.
+//
+// The version only counts the writes that changed something, and a save after a checkpoint can hold writes the log
+// replays differently after a crash, so the version alone can't tell a saved index is stale then. Replaying the log
+// removes the saved indices of every collection it touches instead.
+
+// ErrIndexNotFound is returned when a collection has no index with the given name.
+var ErrIndexNotFound = errors.New("index not found")
+
+// registeredIndex is what a collection needs from its indices without knowing their types
+type registeredIndex interface {
+	save() error
+	drop()
+}
+
+// indexer is what a collection of T needs from its indices without knowing their key types
+type indexer[T any] interface {
+	registeredIndex
+	add(fileID string, data T)
+	remove(fileID string, data T)
+}
+
+// AnyIndex is an index of a collection of T with any key type, as returned by Collection.Index.
+// Type assert it to the index's type, like *Index[T, K], to use it.
+type AnyIndex[T any] interface {
+	indexer[T]
+}
+
+// Kinds of index, recorded in their files. The keys of a prefix index with case folding are folded, so it differs from
+// one without.
+const (
+	kindIndex        = "index"
+	kindOrdered      = "ordered"
+	kindMulti        = "multi"
+	kindPrefix       = "prefix"
+	kindPrefixFolded = "prefix-folded"
+	kindText         = "text"
+)
+
+type indexSnapshot[D comparable] struct {
+	Kind    string
+	Version uint64
+	Index   map[D][]string
+}
+
+func indexPath(dir string, name string) string {
+	return dir + "/index-" + name + ".gob"
+}
+
+// removeSavedIndices removes the saved indices in the collection directory dir, durably
+func removeSavedIndices(dir string) error {
+	paths, err := filepath.Glob(indexPath(dir, "*"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return syncDir(dir)
+}
+
+// indexFile is what every kind of index keeps besides its keys: whether it was dropped, and whether its file is up to
+// date
+type indexFile struct {
+	path         string // index-<name>.gob in the collection's directory
+	kind         string // only a file written by the same kind of index is loaded
+	dropped      bool
+	saved        bool   // the file on disk matches the index at savedVersion
+	savedVersion uint64 // the collection's write version when the index was loaded or last saved
+}
+
+func newIndexFile[T any](c *Collection[T], name string, kind string) indexFile {
+	return indexFile{path: indexPath(c.DB.Path+"/"+c.Name, name), kind: kind, savedVersion: c.state.store.version()}
+}
+
+func (t *indexFile) check() error {
+	if t.dropped {
+		return fmt.Errorf("index has been dropped")
+	}
+	return nil
+}
+
+// saveSnapshot writes what snapshot returns for the collection's write version to the index's file, unless the index
+// is unchanged since it was loaded or last saved
+func (t *indexFile) saveSnapshot(version uint64, snapshot func(version uint64) any) error {
+	if t.saved && version == t.savedVersion {
+		return nil
+	}
+
+	if err := writeGobAtomic(t.path, snapshot(version)); err != nil {
+		return err
+	}
+	t.saved, t.savedVersion = true, version
+	return nil
+}
+
+// openIndex returns the index of c called name if one is open, as long as it has type I and sameOptions returns true
+// for it. Otherwise it registers the index open returns, which is called with c.state.mu held exclusively.
+func openIndex[T any, I registeredIndex](c *Collection[T], name string, sameOptions func(I) bool, open func() (I, error)) (I, error) {
+	var zero I
+	if !isValidIndexName(name) {
+		return zero, fmt.Errorf("invalid index name")
+	}
+
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	if registered, ok := c.state.indices[name]; ok {
+		index, ok := registered.(I)
+		if !ok {
+			return zero, fmt.Errorf("index already open with a different type")
+		}
+		if !sameOptions(index) {
+			return zero, fmt.Errorf("index already open with different options")
+		}
+		return index, nil
+	}
+
+	index, err := open()
+	if err != nil {
+		return zero, err
+	}
+
+	c.state.indices[name] = index
+	return index, nil
+}
+
+// loadKeys returns the keys of the index of c called name as it was saved by the same kind of index, or if that is
+// out of date, as keys returns them for the records. If unique is set, it fails with *ErrDuplicateKey when a key has
+// more than one record. c.state.mu must be held exclusively.
+func loadKeys[T any, D comparable](c *Collection[T], name string, kind string, keys func(T) []D, unique bool) (map[D][]string, indexFile, error) {
+	file := newIndexFile(c, name, kind)
+
+	index, saved, err := loadIndex[D](file.path, kind, file.savedVersion)
+	if err != nil {
+		return nil, file, err
+	}
+	if index == nil {
+		index, err = buildMultiIndex(c, keys)
+		if err != nil {
+			return nil, file, err
+		}
+	}
+	file.saved = saved
+
+	if unique {
+		if err := checkUniqueIndex(name, index); err != nil {
+			return nil, file, err
+		}
+	}
+
+	return index, file, nil
+}
+
+// OpenIndex opens the index of the collection called name, which is kept up to date by all writes to the collection
+// until it is dropped. It is saved to disk, so opening it again after a restart doesn't need to read every record.
+// The name identifies the index, if the extractor changes, so should the name.
+// If the index is already open, it is returned as is.
+func OpenIndex[T any, D comparable](c *Collection[T], name string, extractor func(T) D, opts ...IndexOption) (*Index[T, D], error) {
+	o := indexOptionsOf(opts)
+
+	return openIndex(c, name, func(index *Index[T, D]) bool {
+		return index.unique == o.unique
+	}, func() (*Index[T, D], error) {
+		i := &Index[T, D]{Collection: c, Name: name, Extractor: extractor, unique: o.unique}
+
+		index, file, err := loadKeys(c, name, kindIndex, i.keyOf, o.unique)
+		if err != nil {
+			return nil, err
+		}
+
+		i.Index, i.indexFile = index, file
+		return i, nil
+	})
+}
+
+// loadIndex reads the index saved at path, and returns nil if there is none, or it was saved by another kind of index
+// or doesn't match version. A file that can't be decoded (for example because the key type changed) is treated the
+// same, it gets rebuilt.
+func loadIndex[D comparable](path string, kind string, version uint64) (map[D][]string, bool, error) {
+	var snapshot indexSnapshot[D]
+	ok, err := readSnapshot(path, &snapshot)
+	if err != nil || !ok || snapshot.Kind != kind || snapshot.Version != version {
+		return nil, false, err
+	}
+	if snapshot.Index == nil {
+		snapshot.Index = make(map[D][]string)
+	}
+
+	return snapshot.Index, true, nil
+}
+
+// readSnapshot decodes the index saved at path into snapshot, and returns false if there is none or it can't be decoded
+func readSnapshot(path string, snapshot any) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	dec := gob.NewDecoder(f)
+	if err := dec.Decode(snapshot); err != nil {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Index returns the open index of the collection called name, or ErrIndexNotFound
+func (t *Collection[T]) Index(name string) (AnyIndex[T], error) {
+	t.state.mu.RLock()
+	defer t.state.mu.RUnlock()
+
+	registered, ok := t.state.indices[name]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+	index, ok := registered.(AnyIndex[T])
+	if !ok {
+		return nil, fmt.Errorf("index opened with a different collection type")
+	}
+
+	return index, nil
+}
+
+// ListIndices returns the names of the open indices of the collection, sorted
+func (t *Collection[T]) ListIndices() []string {
+	t.state.mu.RLock()
+	defer t.state.mu.RUnlock()
+
+	names := make([]string, 0, len(t.state.indices))
+	for name := range t.state.indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// DropIndex closes the index called name, so writes stop updating it and its memory can be freed, and removes it
+// from disk. Using it afterwards returns an error. It returns ErrIndexNotFound if the index is neither open nor saved.
+// Dropping the index of a reference drops the reference too, see OpenReference.
+func (t *Collection[T]) DropIndex(name string) error {
+	// Before locking the collection, as the parent collections of its references are locked for this
+	if t.DB.state != nil {
+		t.DB.state.dropReferences(func(ref reference) bool {
+			return ref.childState() == t.state && ref.indexName() == name
+		})
+	}
+
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+
+	registered, open := t.state.indices[name]
+	if open {
+		registered.drop()
+		delete(t.state.indices, name)
+	}
+
+	if t.state.readOnly {
+		if !open {
+			return ErrReadOnly
+		}
+		return nil
+	}
+
+	err := os.Remove(indexPath(t.DB.Path+"/"+t.Name, name))
+	if errors.Is(err, os.ErrNotExist) {
+		if !open {
+			return ErrIndexNotFound
+		}
+		return nil
+	}
+
+	return err
+}
+
+// keyOf returns the key of data, as the one key of a multi index
+func (t *Index[T, D]) keyOf(data T) []D {
+	return []D{t.Extractor(data)}
+}
+
+func (t *Index[T, D]) collection() *Collection[T] {
+	return t.Collection
+}
+
+func (t *Index[T, D]) indexName() string {
+	return t.Name
+}
+
+func (t *Index[T, D]) scan(op Op, key D, fn func(fileIDs []string) bool) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	if op != Eq {
+		return unsupportedOp(t.Name, op)
+	}
+
+	if fileIDs := t.Index[key]; len(fileIDs) > 0 {
+		fn(fileIDs)
+	}
+	return nil
+}
+
+func (t *Index[T, D]) match(op Op, key D, data T) bool {
+	return t.Extractor(data) == key
+}
+
+func (t *Index[T, D]) groups(fn func(key D, fileIDs []string) bool) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	for key, fileIDs := range t.Index {
+		if len(fileIDs) > 0 && !fn(key, fileIDs) {
+			break
+		}
+	}
+	return nil
+}
+
+func (t *Index[T, D]) add(fileID string, data T) {
+	key := t.Extractor(data)
+	t.Index[key] = append(t.Index[key], fileID)
+}
+
+func (t *Index[T, D]) remove(fileID string, data T) {
+	key := t.Extractor(data)
+	fileIDs := t.Index[key]
+	for i, id := range fileIDs {
+		if id == fileID {
+			fileIDs = append(fileIDs[:i], fileIDs[i+1:]...)
+			break
+		}
+	}
+
+	if len(fileIDs) == 0 {
+		delete(t.Index, key)
+	} else {
+		t.Index[key] = fileIDs
+	}
+}
+
+func (t *Index[T, D]) checkWrites(changes []recordChange[T]) error {
+	if !t.unique {
+		return nil
+	}
+
+	return checkUnique(t.Name, changes, t.keyOf, func(key D) []string { return t.Index[key] })
+}
+
+// save writes the index to disk, unless it is unchanged since it was loaded or last saved
+func (t *Index[T, D]) save() error {
+	return t.saveSnapshot(t.Collection.state.store.version(), func(version uint64) any {
+		return indexSnapshot[D]{Kind: t.kind, Version: version, Index: t.Index}
+	})
+}
+
+func (t *Index[T, D]) drop() {
+	t.dropped = true
+	t.Index = nil
+}
+
+// saveIndices writes the indices of the collection that changed since they were loaded or last saved, the caller
+// holds t.mu
+func (t *collectionState) saveIndices() error {
+	if t.readOnly {
+		return nil
+	}
+
+	for _, index := range t.indices {
+		if err := index.save(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
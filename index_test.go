@@ -0,0 +1,366 @@
+package gobble
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPersistentIndex(t *testing.T) {
+	for _, storage := range []Storage{FileStorage, SegmentStorage} {
+		path := fmt.Sprintf("testdb-persist-%d", storage)
+
+		calls := 0
+		byAge := func(p ExamplePersonStruct) int {
+			calls++
+			return p.Age
+		}
+		open := func() (*DB, Collection[ExamplePersonStruct], *Index[ExamplePersonStruct, int]) {
+			db, err := OpenDB(path, WithStorage(storage))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+			index, err := OpenIndex[ExamplePersonStruct, int](&c, "by-age", byAge)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return &db, c, index
+		}
+
+		db, c, index := open()
+		for i := 0; i < 10; i++ {
+			_ = c.Insert(ExamplePersonStruct{Name: fmt.Sprintf("ExamplePersonStruct %d", i), Age: i % 3})
+		}
+		_ = index.Del(1)
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path + "/testcollection/index-by-age.gob"); err != nil {
+			t.Fatal(err)
+		}
+
+		// Loaded as saved, without reading the records
+		calls = 0
+		db, c, index = open()
+		if calls != 0 {
+			t.Fatalf("index rebuilt although it was up to date (storage %d)", storage)
+		}
+		if n, _ := index.Num(0); n != 4 {
+			t.Fatalf("expected 4 records with key 0, got %d", n)
+		}
+		if n, _ := index.Num(1); n != 0 {
+			t.Fatalf("expected no records with key 1, got %d", n)
+		}
+		_ = c.Insert(ExamplePersonStruct{Name: "ExamplePersonStruct 10", Age: 2})
+		_ = db.Close()
+
+		// Written to without the index open, so it is stale
+		db2, _ := OpenDB(path)
+		c2, _ := OpenCollection[ExamplePersonStruct](db2, "testcollection")
+		_ = c2.Insert(ExamplePersonStruct{Name: "ExamplePersonStruct 11", Age: 2})
+		_ = db2.Close()
+
+		calls = 0
+		db, _, index = open()
+		if calls == 0 {
+			t.Fatalf("stale index not rebuilt (storage %d)", storage)
+		}
+		x, _ := index.Get(2)
+		if len(x) != 5 {
+			t.Fatalf("expected 5 records with key 2, got %v", x)
+		}
+		_ = db.Close()
+
+		_ = os.RemoveAll(path)
+	}
+}
+
+func TestIndexSavedAtCheckpoint(t *testing.T) {
+	db, _ := OpenDB("testdb-checkpoint-index")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-checkpoint-index")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	byAge, _ := OpenIndex[ExamplePersonStruct, int](&c, "by-age", func(p ExamplePersonStruct) int { return p.Age })
+	for i := 0; i < 10; i++ {
+		_ = c.Insert(ExamplePersonStruct{Name: fmt.Sprintf("ExamplePersonStruct %d", i), Age: i % 3})
+	}
+
+	// Saved in the background once the checkpoint is done, without closing the DB
+	if err := db.state.wal.checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	path := indexPath("testdb-checkpoint-index/testcollection", "by-age")
+	var index map[int][]string
+	for i := 0; i < 100 && index == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		c.state.mu.RLock()
+		index, _, _ = loadIndex[int](path, kindIndex, c.state.store.version())
+		c.state.mu.RUnlock()
+	}
+	if len(index[0]) != 4 {
+		t.Fatalf("index not saved at the checkpoint, got %v", index)
+	}
+	if n, _ := byAge.Num(0); n != 4 {
+		t.Fatalf("expected 4 records with key 0, got %d", n)
+	}
+}
+
+func TestIndexRegistry(t *testing.T) {
+	db, _ := OpenDB("testdb-registry")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-registry")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	_ = c.Insert(ExamplePersonStruct{Name: "ExamplePersonStruct 1", Age: 1})
+
+	byName, _ := OpenIndex[ExamplePersonStruct, string](&c, "name", func(p ExamplePersonStruct) string { return p.Name })
+	byAge, _ := OpenIndex[ExamplePersonStruct, int](&c, "age", func(p ExamplePersonStruct) int { return p.Age })
+	if _, err := OpenIndex[ExamplePersonStruct, int](&c, "-age", func(p ExamplePersonStruct) int { return p.Age }); err == nil {
+		t.Fatal("invalid index name accepted")
+	}
+
+	if _, err := OpenIndex[ExamplePersonStruct, string](&c, "age", func(p ExamplePersonStruct) string { return p.Name }); err == nil {
+		t.Fatal("index opened again with a different key type")
+	}
+	if again, _ := OpenIndex[ExamplePersonStruct, int](&c, "age", func(p ExamplePersonStruct) int { return p.Age }); again != byAge {
+		t.Fatal("open index not returned by OpenIndex")
+	}
+
+	if names := c.ListIndices(); len(names) != 2 || names[0] != "age" || names[1] != "name" {
+		t.Fatalf("unexpected indices %v", names)
+	}
+
+	// Indices are shared by all Collection values for the collection
+	c2, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	_ = c2.Insert(ExamplePersonStruct{Name: "ExamplePersonStruct 2", Age: 2})
+	index, err := c2.Index("age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != byAge {
+		t.Fatal("Index returned a different index")
+	}
+	if n, _ := index.(*Index[ExamplePersonStruct, int]).Num(2); n != 1 {
+		t.Fatalf("index not updated by a write through another Collection value")
+	}
+	if _, err := c2.Index("other"); !errors.Is(err, ErrIndexNotFound) {
+		t.Fatalf("expected ErrIndexNotFound, got %v", err)
+	}
+
+	if err := c.DropIndex("age"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := byAge.Get(1); err == nil {
+		t.Fatal("dropped index still usable")
+	}
+	if err := c.DropIndex("age"); !errors.Is(err, ErrIndexNotFound) {
+		t.Fatalf("expected ErrIndexNotFound, got %v", err)
+	}
+	if names := c.ListIndices(); len(names) != 1 || names[0] != "name" {
+		t.Fatalf("unexpected indices %v", names)
+	}
+	if x, _ := byName.Get("ExamplePersonStruct 2"); len(x) != 1 {
+		t.Fatalf("remaining index not usable after drop: %v", x)
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	db, _ := OpenDB("testdb-unique")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-unique")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	_ = c.Insert(ExamplePersonStruct{Name: "A", Age: 1})
+	_ = c.Insert(ExamplePersonStruct{Name: "B", Age: 1})
+
+	if _, err := OpenIndex[ExamplePersonStruct, int](&c, "age", func(p ExamplePersonStruct) int { return p.Age }, WithUnique()); err == nil {
+		t.Fatal("unique index opened over duplicate keys")
+	}
+	byName, err := OpenIndex[ExamplePersonStruct, string](&c, "name", func(p ExamplePersonStruct) string { return p.Name }, WithUnique())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dup *ErrDuplicateKey
+	if _, err := c.InsertWithID(ExamplePersonStruct{Name: "A", Age: 2}); !errors.As(err, &dup) || dup.Key != "A" || dup.ID != 1 {
+		t.Fatalf("expected a duplicate key error for A, got %v", err)
+	}
+	if err := c.ReplaceByID(2, ExamplePersonStruct{Name: "A"}); !errors.As(err, &dup) {
+		t.Fatalf("expected a duplicate key error, got %v", err)
+	}
+
+	// Swapping the names of two records is fine, giving both the same name isn't and leaves both as they were
+	swap := func(p ExamplePersonStruct) ExamplePersonStruct {
+		p.Name = map[string]string{"A": "B", "B": "A"}[p.Name]
+		return p
+	}
+	if err := c.Modify(func(p ExamplePersonStruct) bool { return true }, swap); err != nil {
+		t.Fatal(err)
+	}
+	err = c.Modify(func(p ExamplePersonStruct) bool { return true }, func(p ExamplePersonStruct) ExamplePersonStruct {
+		p.Name = "C"
+		return p
+	})
+	if !errors.As(err, &dup) || dup.Key != "C" {
+		t.Fatalf("expected a duplicate key error for C, got %v", err)
+	}
+	if err := byName.Mod("A", func(p ExamplePersonStruct) ExamplePersonStruct { p.Name = "B"; return p }); !errors.As(err, &dup) {
+		t.Fatalf("expected a duplicate key error, got %v", err)
+	}
+	if p, _ := c.GetByID(1); p.Name != "B" {
+		t.Fatalf("record changed by a refused write: %v", p)
+	}
+	if n, _ := byName.Num("C"); n != 0 {
+		t.Fatal("index changed by a refused write")
+	}
+
+	// Transactions are checked as a whole when they commit
+	err = db.Update(func(tx *Tx) error {
+		tc := c.Tx(tx)
+		_ = tc.Delete(func(p ExamplePersonStruct) bool { return p.Name == "A" })
+		return tc.Insert(ExamplePersonStruct{Name: "A", Age: 3})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *Tx) error {
+		tc := c.Tx(tx)
+		_ = tc.Insert(ExamplePersonStruct{Name: "D"})
+		return tc.Insert(ExamplePersonStruct{Name: "D"})
+	})
+	if !errors.As(err, &dup) || dup.Key != "D" {
+		t.Fatalf("expected a duplicate key error for D, got %v", err)
+	}
+	if n, _ := c.Number(); n != 2 {
+		t.Fatalf("expected 2 records, got %d", n)
+	}
+}
+
+func TestIndexUpsert(t *testing.T) {
+	db, _ := OpenDB("testdb-upsert")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-upsert")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	byName, _ := OpenIndex[ExamplePersonStruct, string](&c, "name", func(p ExamplePersonStruct) string { return p.Name }, WithUnique())
+
+	newPerson := func() ExamplePersonStruct { return ExamplePersonStruct{Name: "Alice", Age: 1} }
+	birthday := func(p ExamplePersonStruct) ExamplePersonStruct { p.Age++; return p }
+
+	// Only one of the concurrent upserts inserts, the others see its record
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	inserts := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			inserted, err := byName.Upsert("Alice", newPerson, birthday)
+			if err != nil {
+				t.Error(err)
+			}
+			if inserted {
+				mu.Lock()
+				inserts++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	people, _ := byName.Get("Alice")
+	if inserts != 1 || len(people) != 1 || people[0].Age != 10 {
+		t.Fatalf("unexpected upserts: %d inserted, records %v", inserts, people)
+	}
+
+	if _, err := byName.Upsert("Bob", newPerson, birthday); err == nil {
+		t.Fatal("expected an error inserting a record without the key")
+	}
+	if n, _ := c.Number(); n != 1 {
+		t.Fatalf("expected 1 record, got %d", n)
+	}
+}
+
+func TestIndexEmptiedKey(t *testing.T) {
+	db, _ := OpenDB("testdb-emptied-key")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-emptied-key")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	byAge, _ := OpenIndex[ExamplePersonStruct, int](&c, "age", func(p ExamplePersonStruct) int { return p.Age })
+	id, _ := c.InsertWithID(ExamplePersonStruct{Name: "ExamplePersonStruct 1", Age: 1})
+	_ = c.Insert(ExamplePersonStruct{Name: "ExamplePersonStruct 2", Age: 2})
+
+	// A key whose last record is gone is the same as a key that never had any
+	_ = c.DeleteByID(id)
+	if _, ok := byAge.Index[1]; ok {
+		t.Fatal("emptied key left in the index")
+	}
+	if x, err := byAge.Get(1); err != nil || x == nil || len(x) != 0 {
+		t.Fatalf("expected an empty slice for an emptied key, got %#v, %v", x, err)
+	}
+	called := false
+	_ = byAge.Mod(1, func(p ExamplePersonStruct) ExamplePersonStruct { called = true; return p })
+	if called {
+		t.Fatal("updater called for an emptied key")
+	}
+}
+
+func TestIndexAfterCrash(t *testing.T) {
+	db, _ := OpenDB("testdb-crash-index")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-crash-index")
+		_ = os.RemoveAll("testdb-crash-index-copy")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	byName, _ := OpenIndex[ExamplePersonStruct, string](&c, "by-name", func(p ExamplePersonStruct) string { return p.Name })
+	_ = c.Insert(ExamplePersonStruct{Name: "B"})
+	if err := db.state.wal.checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The index is saved after writes that are only in the log, one of them a remove replaying won't count
+	_ = c.Insert(ExamplePersonStruct{Name: "A"})
+	_ = byName.Del("B")
+	c.state.mu.Lock()
+	err := c.state.saveIndices()
+	c.state.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Insert(ExamplePersonStruct{Name: "C"})
+
+	// The files as the process leaves them if it is killed now
+	if err := os.CopyFS("testdb-crash-index-copy", os.DirFS("testdb-crash-index")); err != nil {
+		t.Fatal(err)
+	}
+
+	crashed, err := OpenDB("testdb-crash-index-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = crashed.Close()
+	}()
+	c, _ = OpenCollection[ExamplePersonStruct](crashed, "testcollection")
+	byName, _ = OpenIndex[ExamplePersonStruct, string](&c, "by-name", func(p ExamplePersonStruct) string { return p.Name })
+	for _, name := range []string{"A", "C"} {
+		if found, _ := byName.Get(name); len(found) != 1 {
+			t.Fatalf("expected 1 record named %s after the crash, got %v", name, found)
+		}
+	}
+	if found, _ := byName.Get("B"); len(found) != 0 {
+		t.Fatalf("expected no record named B after the crash, got %v", found)
+	}
+}
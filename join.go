@@ -0,0 +1,87 @@
+package gobble
+
+import (
+	"iter"
+)
+
+// Joins
+//
+// A join goes through the records of the left collection with Scan, and for each of them looks up the records of the
+// right collection with its key in an index (an index nested loop join), so the right collection is never scanned.
+
+// JoinRow is a record of the left collection of a join, with the records of the right collection matching it.
+type JoinRow[A any, B any] struct {
+	Left  A
+	Right []B
+}
+
+// Join returns an iterator over the records of left that have matching records in right, each with those records.
+// The records of right match a record of left when their key in rightIndex equals key(left). rightIndex can be any
+// index with keys, for a MultiIndex a record of right matches if it has the key among its keys. If reading a record
+// fails the iterator yields the error and stops, like Scan.
+//
+//	for row, err := range gobble.Join(&orders, customersByID, func(o Order) int { return o.CustomerID }) { ... }
+func Join[A any, B any, K any](left *Collection[A], rightIndex keyIndex[B, K], key func(A) K) iter.Seq2[JoinRow[A, B], error] {
+	return join(left, rightIndex, key, false)
+}
+
+// LeftJoin is Join, but also returns the records of left without matching records in right, with an empty slice.
+func LeftJoin[A any, B any, K any](left *Collection[A], rightIndex keyIndex[B, K], key func(A) K) iter.Seq2[JoinRow[A, B], error] {
+	return join(left, rightIndex, key, true)
+}
+
+func join[A any, B any, K any](left *Collection[A], rightIndex keyIndex[B, K], key func(A) K, keepUnmatched bool) iter.Seq2[JoinRow[A, B], error] {
+	return func(yield func(JoinRow[A, B], error) bool) {
+		for data, err := range left.Scan(func(A) bool { return true }) {
+			if err != nil {
+				yield(JoinRow[A, B]{}, err)
+				return
+			}
+
+			matches, err := lookup(rightIndex, key(data))
+			if err != nil {
+				yield(JoinRow[A, B]{}, err)
+				return
+			}
+
+			if len(matches) == 0 && !keepUnmatched {
+				continue
+			}
+			if !yield(JoinRow[A, B]{Left: data, Right: matches}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// lookup returns the records with key in index, in ID order
+func lookup[T any, K any](index keyIndex[T, K], key K) ([]T, error) {
+	c := index.collection()
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	var fileIDs []string
+	err := index.scan(Eq, key, func(ids []string) bool {
+		fileIDs = append(fileIDs, ids...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := idsAfter(fileIDs, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []T{}
+	for _, id := range ids {
+		data, err := c.read(id)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, data)
+	}
+
+	return results, nil
+}
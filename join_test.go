@@ -0,0 +1,77 @@
+package gobble
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+type examplePurchase struct {
+	Item  string
+	Buyer string
+}
+
+func TestJoin(t *testing.T) {
+	db, _ := OpenDB("testdb-join")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-join")
+	}()
+	people, _ := OpenCollection[ExamplePersonStruct](db, "people")
+	orders, _ := OpenCollection[examplePurchase](db, "orders")
+
+	_ = people.Insert(ExamplePersonStruct{Name: "Alice", Age: 30})
+	_ = people.Insert(ExamplePersonStruct{Name: "Bob", Age: 40})
+	_ = people.Insert(ExamplePersonStruct{Name: "Alice", Age: 50})
+	_ = orders.Insert(examplePurchase{Item: "book", Buyer: "Alice"})
+	_ = orders.Insert(examplePurchase{Item: "pen", Buyer: "Carol"})
+	_ = orders.Insert(examplePurchase{Item: "lamp", Buyer: "Bob"})
+
+	byName, _ := OpenIndex[ExamplePersonStruct, string](&people, "name", func(p ExamplePersonStruct) string { return p.Name })
+	buyer := func(o examplePurchase) string { return o.Buyer }
+
+	var got []string
+	for row, err := range Join(&orders, byName, buyer) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprint(row.Left.Item, row.Right))
+	}
+	if fmt.Sprint(got) != "[book[{Alice 30} {Alice 50}] lamp[{Bob 40}]]" {
+		t.Fatalf("unexpected inner join %v", got)
+	}
+
+	got = nil
+	for row, err := range LeftJoin(&orders, byName, buyer) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprint(row.Left.Item, len(row.Right)))
+		if row.Left.Item == "pen" {
+			break
+		}
+	}
+	if fmt.Sprint(got) != "[book2 pen0]" {
+		t.Fatalf("unexpected left join %v", got)
+	}
+
+	// Any index with keys works, here joining people with the orders of buyers with the same name
+	byBuyer, _ := OpenMultiIndex[examplePurchase, string](&orders, "buyer", func(o examplePurchase) []string { return []string{o.Buyer} })
+	n := 0
+	for row, err := range Join(&people, byBuyer, func(p ExamplePersonStruct) string { return p.Name }) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += len(row.Right)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 joined orders, got %d", n)
+	}
+
+	_ = people.DropIndex("name")
+	for row, err := range Join(&orders, byName, buyer) {
+		if err == nil {
+			t.Fatalf("dropped index joined %v", row)
+		}
+	}
+}
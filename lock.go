@@ -0,0 +1,53 @@
+package gobble
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// LockMode selects how OpenDB locks the DB directory against other processes.
+type LockMode int
+
+const (
+	// LockExclusive is for a process that writes to the DB, no other process can open it at the same time.
+	LockExclusive LockMode = iota
+	// LockShared is for a process that only reads from the DB. Any number of them can open it at the same time,
+	// but not while a process holds it with LockExclusive. Writes fail with ErrReadOnly.
+	LockShared
+)
+
+var (
+	// ErrLocked is returned by OpenDB when another process (or another OpenDB in this one) holds a conflicting lock.
+	ErrLocked = errors.New("database is locked")
+	// ErrReadOnly is returned by writes to a DB opened with LockShared.
+	ErrReadOnly = errors.New("database is opened read-only")
+)
+
+const lockFileName = ".lock"
+
+// WithLockMode sets how OpenDB locks the DB directory, LockExclusive by default.
+func WithLockMode(mode LockMode) Option {
+	return func(o *options) {
+		o.lockMode = mode
+	}
+}
+
+// acquireLock takes the advisory lock on the lock file in the DB directory without waiting.
+// The lock is held until the returned file is closed.
+func acquireLock(path string, mode LockMode) (*os.File, error) {
+	f, err := os.OpenFile(path+"/"+lockFileName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(f, mode); err != nil {
+		_ = f.Close()
+		if errors.Is(err, ErrLocked) {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
@@ -0,0 +1,11 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package gobble
+
+import "os"
+
+// There is no flock here, so the lock file is created but not locked. Opening the same DB from multiple processes is
+// not detected on these platforms.
+func lockFile(f *os.File, mode LockMode) error {
+	return nil
+}
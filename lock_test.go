@@ -0,0 +1,68 @@
+package gobble
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// flock locks belong to the open file, so a second OpenDB in the same process conflicts just like another process would
+
+func TestLocking(t *testing.T) {
+	db, err := OpenDB("testdb-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll("testdb-lock") }()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	_ = c.Insert(ExamplePersonStruct{Name: "ExamplePersonStruct 1", Age: 1})
+
+	if _, err := OpenDB("testdb-lock"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked for a second writer, got %v", err)
+	}
+	if _, err := OpenDB("testdb-lock", WithLockMode(LockShared)); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked for a reader while a writer holds the lock, got %v", err)
+	}
+
+	names, _ := db.ListCollections()
+	if len(names) != 1 || names[0] != "testcollection" {
+		t.Fatalf("lock file listed as a collection: %v", names)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r1, err := OpenDB("testdb-lock", WithLockMode(LockShared))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := OpenDB("testdb-lock", WithLockMode(LockShared))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenDB("testdb-lock"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked for a writer while readers hold the lock, got %v", err)
+	}
+
+	c, _ = OpenCollection[ExamplePersonStruct](r1, "testcollection")
+	x, _ := c.Select(func(p ExamplePersonStruct) bool { return true })
+	if !verifyItemsEqual(x, []ExamplePersonStruct{{Name: "ExamplePersonStruct 1", Age: 1}}) {
+		t.Fatal("reader can't see the data")
+	}
+	if err := c.Insert(ExamplePersonStruct{Name: "ExamplePersonStruct 2", Age: 2}); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	if _, err := OpenCollection[ExamplePersonStruct](r2, "othercollection"); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly when creating a collection, got %v", err)
+	}
+
+	_ = r1.Close()
+	_ = r2.Close()
+
+	db, err = OpenDB("testdb-lock")
+	if err != nil {
+		t.Fatalf("lock not released by Close: %v", err)
+	}
+	_ = db.Close()
+}
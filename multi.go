@@ -0,0 +1,217 @@
+package gobble
+
+import (
+	"slices"
+)
+
+// MultiIndex is an index whose extractor returns any number of keys for a record, like its tags, and lists the record
+// under each of them. It is safe for concurrent use like its collection, but Index must not be accessed directly while
+// other goroutines use the collection.
+type MultiIndex[T any, K comparable] struct {
+	Collection *Collection[T]
+	Name       string
+	Index      map[K][]string
+	Extractor  func(T) []K
+
+	unique bool
+	indexFile
+}
+
+// OpenMultiIndex is OpenIndex for a MultiIndex. A key returned more than once for the same record lists it only once.
+// With WithUnique, no two records can have a key in common.
+func OpenMultiIndex[T any, K comparable](c *Collection[T], name string, extractor func(T) []K, opts ...IndexOption) (*MultiIndex[T, K], error) {
+	o := indexOptionsOf(opts)
+
+	return openIndex(c, name, func(index *MultiIndex[T, K]) bool {
+		return index.unique == o.unique
+	}, func() (*MultiIndex[T, K], error) {
+		i := &MultiIndex[T, K]{Collection: c, Name: name, Extractor: extractor, unique: o.unique}
+
+		index, file, err := loadKeys(c, name, kindMulti, i.keys, o.unique)
+		if err != nil {
+			return nil, err
+		}
+
+		i.Index, i.indexFile = index, file
+		return i, nil
+	})
+}
+
+// Get returns the records listed under key
+func (t *MultiIndex[T, K]) Get(key K) ([]T, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
+	fileIDs, ok := t.Index[key]
+	if !ok {
+		return []T{}, nil
+	}
+
+	return t.Collection.readFileIDs(fileIDs)
+}
+
+// Del deletes the records listed under key, which removes them from all their other keys too
+func (t *MultiIndex[T, K]) Del(key K) error {
+	if t.Collection.referenced() {
+		return t.Collection.deleteReferenced(func() ([]string, error) {
+			return t.Index[key], t.check()
+		})
+	}
+
+	t.Collection.state.mu.Lock()
+	defer t.Collection.state.mu.Unlock()
+
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	if t.Collection.state.readOnly {
+		return ErrReadOnly
+	}
+
+	fileIDs, ok := t.Index[key]
+	if !ok {
+		return nil
+	}
+
+	fileIDsCopy := make([]string, len(fileIDs))
+	copy(fileIDsCopy, fileIDs)
+
+	return t.Collection.deleteFileIDs(fileIDsCopy)
+}
+
+// Mod updates the records listed under key, they are then listed under the keys of the updated records
+func (t *MultiIndex[T, K]) Mod(key K, updater Updater[T]) error {
+	t.Collection.state.mu.Lock()
+	defer t.Collection.state.mu.Unlock()
+
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	if t.Collection.state.readOnly {
+		return ErrReadOnly
+	}
+
+	fileIDs, ok := t.Index[key]
+	if !ok {
+		return nil
+	}
+
+	return t.Collection.modifyFileIDs(fileIDs, updater)
+}
+
+func (t *MultiIndex[T, K]) Num(key K) (int, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return 0, err
+	}
+
+	return len(t.Index[key]), nil
+}
+
+func (t *MultiIndex[T, K]) collection() *Collection[T] {
+	return t.Collection
+}
+
+func (t *MultiIndex[T, K]) indexName() string {
+	return t.Name
+}
+
+// scan finds the records that have key among their keys
+func (t *MultiIndex[T, K]) scan(op Op, key K, fn func(fileIDs []string) bool) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	if op != Eq {
+		return unsupportedOp(t.Name, op)
+	}
+
+	if fileIDs := t.Index[key]; len(fileIDs) > 0 {
+		fn(fileIDs)
+	}
+	return nil
+}
+
+func (t *MultiIndex[T, K]) match(op Op, key K, data T) bool {
+	return slices.Contains(t.Extractor(data), key)
+}
+
+func (t *MultiIndex[T, K]) groups(fn func(key K, fileIDs []string) bool) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	for key, fileIDs := range t.Index {
+		if !fn(key, fileIDs) {
+			break
+		}
+	}
+	return nil
+}
+
+// keys returns the keys of data without repeats, in the order the extractor returned them
+func (t *MultiIndex[T, K]) keys(data T) []K {
+	keys := t.Extractor(data)
+
+	seen := make(map[K]bool, len(keys))
+	unique := keys[:0:0]
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+
+	return unique
+}
+
+func (t *MultiIndex[T, K]) add(fileID string, data T) {
+	for _, key := range t.keys(data) {
+		t.Index[key] = append(t.Index[key], fileID)
+	}
+}
+
+func (t *MultiIndex[T, K]) remove(fileID string, data T) {
+	for _, key := range t.keys(data) {
+		fileIDs := t.Index[key]
+		for i, id := range fileIDs {
+			if id == fileID {
+				fileIDs = append(fileIDs[:i], fileIDs[i+1:]...)
+				break
+			}
+		}
+
+		if len(fileIDs) == 0 {
+			delete(t.Index, key)
+		} else {
+			t.Index[key] = fileIDs
+		}
+	}
+}
+
+func (t *MultiIndex[T, K]) checkWrites(changes []recordChange[T]) error {
+	if !t.unique {
+		return nil
+	}
+
+	return checkUnique(t.Name, changes, t.keys, func(key K) []string { return t.Index[key] })
+}
+
+// save writes the index the same way as an Index, a record just shows up under more than one key
+func (t *MultiIndex[T, K]) save() error {
+	return t.saveSnapshot(t.Collection.state.store.version(), func(version uint64) any {
+		return indexSnapshot[K]{Kind: t.kind, Version: version, Index: t.Index}
+	})
+}
+
+func (t *MultiIndex[T, K]) drop() {
+	t.dropped = true
+	t.Index = nil
+}
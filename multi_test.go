@@ -0,0 +1,81 @@
+package gobble
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+type taggedStruct struct {
+	Title string
+	Tags  []string
+}
+
+func TestMultiIndex(t *testing.T) {
+	db, _ := OpenDB("testdb-multi")
+	defer func() {
+		_ = os.RemoveAll("testdb-multi")
+	}()
+	c, _ := OpenCollection[taggedStruct](db, "tickets")
+
+	_ = c.Insert(taggedStruct{"A", []string{"urgent", "billing"}})
+	_ = c.Insert(taggedStruct{"B", []string{"billing", "billing"}})
+	byTag, err := OpenMultiIndex[taggedStruct, string](&c, "tags", func(t taggedStruct) []string { return t.Tags })
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Insert(taggedStruct{"C", []string{"urgent"}})
+
+	if x, _ := byTag.Get("urgent"); len(x) != 2 {
+		t.Fatalf("expected 2 urgent records, got %v", x)
+	}
+	if n, _ := byTag.Num("billing"); n != 2 {
+		t.Fatalf("expected 2 billing records, got %d", n)
+	}
+
+	// Stale keys are removed for all the old tags
+	_ = byTag.Mod("urgent", func(t taggedStruct) taggedStruct {
+		t.Tags = []string{"done"}
+		return t
+	})
+	if n, _ := byTag.Num("urgent"); n != 0 {
+		t.Fatalf("expected no urgent records, got %d", n)
+	}
+	if x, _ := byTag.Get("billing"); len(x) != 1 || x[0].Title != "B" {
+		t.Fatalf("expected only B under billing, got %v", x)
+	}
+	_ = byTag.Del("done")
+	if n, _ := c.Number(); n != 1 {
+		t.Fatalf("expected 1 record, got %d", n)
+	}
+	if _, ok := byTag.Index["urgent"]; ok {
+		t.Fatal("empty key left in the index")
+	}
+
+	_ = db.Close()
+
+	db, _ = OpenDB("testdb-multi")
+	c, _ = OpenCollection[taggedStruct](db, "tickets")
+	byTag, _ = OpenMultiIndex[taggedStruct, string](&c, "tags", func(t taggedStruct) []string { return t.Tags })
+	if x, _ := byTag.Get("billing"); len(x) != 1 || x[0].Title != "B" {
+		t.Fatalf("unexpected records after reopening %v", x)
+	}
+	_ = c.DeleteByID(2)
+	if n, _ := byTag.Num("billing"); n != 0 {
+		t.Fatalf("expected no billing records, got %d", n)
+	}
+
+	unique, err := OpenMultiIndex[taggedStruct, string](&c, "unique-tags", func(t taggedStruct) []string { return t.Tags }, WithUnique())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Insert(taggedStruct{"D", []string{"a", "b", "a"}})
+	var dup *ErrDuplicateKey
+	if err := c.Insert(taggedStruct{"E", []string{"c", "b"}}); !errors.As(err, &dup) || dup.Key != "b" {
+		t.Fatalf("expected a duplicate key error for b, got %v", err)
+	}
+	if n, _ := unique.Num("c"); n != 0 {
+		t.Fatal("refused record indexed")
+	}
+	_ = db.Close()
+}
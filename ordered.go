@@ -0,0 +1,295 @@
+package gobble
+
+import (
+	"cmp"
+)
+
+// OrderedIndex is an index that keeps its keys sorted, so besides looking up a key it can find the records in a range
+// of keys, or go through all of them in order. It is safe for concurrent use like its collection.
+type OrderedIndex[T any, K cmp.Ordered] struct {
+	Collection *Collection[T]
+	Name       string
+	Extractor  func(T) K
+
+	keys   *skipList[K]
+	unique bool
+	indexFile
+}
+
+// OpenOrderedIndex is OpenIndex for an OrderedIndex.
+func OpenOrderedIndex[T any, K cmp.Ordered](c *Collection[T], name string, extractor func(T) K, opts ...IndexOption) (*OrderedIndex[T, K], error) {
+	o := indexOptionsOf(opts)
+
+	return openIndex(c, name, func(index *OrderedIndex[T, K]) bool {
+		return index.unique == o.unique
+	}, func() (*OrderedIndex[T, K], error) {
+		i := &OrderedIndex[T, K]{Collection: c, Name: name, Extractor: extractor, keys: newSkipList[K](), unique: o.unique}
+
+		index, file, err := loadKeys(c, name, kindOrdered, i.keyOf, o.unique)
+		if err != nil {
+			return nil, err
+		}
+		for key, fileIDs := range index {
+			for _, fileID := range fileIDs {
+				i.keys.add(key, fileID)
+			}
+		}
+
+		i.indexFile = file
+		return i, nil
+	})
+}
+
+// Get returns the records with key, like Index.Get
+func (t *OrderedIndex[T, K]) Get(key K) ([]T, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
+	n := t.keys.get(key)
+	if n == nil {
+		return []T{}, nil
+	}
+
+	return t.Collection.readFileIDs(n.fileIDs)
+}
+
+func (t *OrderedIndex[T, K]) Num(key K) (int, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return 0, err
+	}
+
+	n := t.keys.get(key)
+	if n == nil {
+		return 0, nil
+	}
+
+	return len(n.fileIDs), nil
+}
+
+// Range returns the records with a key from lo to hi (both included), in key order. If limit is more than 0, at most
+// that many are returned.
+func (t *OrderedIndex[T, K]) Range(lo, hi K, limit int) ([]T, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
+	results := []T{}
+	for n := t.keys.seek(lo); n != nil && cmp.Compare(n.key, hi) <= 0; n = n.next[0] {
+		for _, fileID := range n.fileIDs {
+			if limit > 0 && len(results) >= limit {
+				return results, nil
+			}
+
+			data, err := t.Collection.readFileID(fileID)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, data)
+		}
+	}
+
+	return results, nil
+}
+
+// Min returns a record with the smallest key, or ErrNotFound if there are no records
+func (t *OrderedIndex[T, K]) Min() (T, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	return t.end(t.keys.first())
+}
+
+// Max returns a record with the largest key, or ErrNotFound if there are no records
+func (t *OrderedIndex[T, K]) Max() (T, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	return t.end(t.keys.tail)
+}
+
+func (t *OrderedIndex[T, K]) end(n *skipNode[K]) (T, error) {
+	var data T
+	if err := t.check(); err != nil {
+		return data, err
+	}
+	if n == nil {
+		return data, ErrNotFound
+	}
+
+	return t.Collection.readFileID(n.fileIDs[0])
+}
+
+// Ascend calls fn with every record and its key in ascending key order, until fn returns false.
+// The collection is locked for reading meanwhile, so fn must not write to it.
+func (t *OrderedIndex[T, K]) Ascend(fn func(key K, data T) bool) error {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	for n := t.keys.first(); n != nil; n = n.next[0] {
+		if ok, err := t.visit(n, fn); !ok || err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Descend is Ascend in descending key order
+func (t *OrderedIndex[T, K]) Descend(fn func(key K, data T) bool) error {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	for n := t.keys.tail; n != nil; n = n.prev {
+		if ok, err := t.visit(n, fn); !ok || err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// visit calls fn with the records of n, and returns whether to go on
+func (t *OrderedIndex[T, K]) visit(n *skipNode[K], fn func(key K, data T) bool) (bool, error) {
+	for _, fileID := range n.fileIDs {
+		data, err := t.Collection.readFileID(fileID)
+		if err != nil {
+			return false, err
+		}
+		if !fn(n.key, data) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (t *OrderedIndex[T, K]) collection() *Collection[T] {
+	return t.Collection
+}
+
+func (t *OrderedIndex[T, K]) indexName() string {
+	return t.Name
+}
+
+func (t *OrderedIndex[T, K]) scan(op Op, key K, fn func(fileIDs []string) bool) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	var n *skipNode[K]
+	switch op {
+	case Eq:
+		if n = t.keys.get(key); n != nil {
+			fn(n.fileIDs)
+		}
+		return nil
+	case Lt, Le:
+		n = t.keys.first()
+	case Gt, Ge:
+		n = t.keys.seek(key)
+	default:
+		return unsupportedOp(t.Name, op)
+	}
+
+	for ; n != nil; n = n.next[0] {
+		if op == Gt && cmp.Compare(n.key, key) == 0 {
+			continue
+		}
+		if (op == Lt && cmp.Compare(n.key, key) >= 0) || (op == Le && cmp.Compare(n.key, key) > 0) {
+			break
+		}
+		if !fn(n.fileIDs) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (t *OrderedIndex[T, K]) match(op Op, key K, data T) bool {
+	c := cmp.Compare(t.Extractor(data), key)
+	switch op {
+	case Lt:
+		return c < 0
+	case Le:
+		return c <= 0
+	case Gt:
+		return c > 0
+	case Ge:
+		return c >= 0
+	default:
+		return c == 0
+	}
+}
+
+func (t *OrderedIndex[T, K]) groups(fn func(key K, fileIDs []string) bool) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	for n := t.keys.first(); n != nil; n = n.next[0] {
+		if !fn(n.key, n.fileIDs) {
+			break
+		}
+	}
+	return nil
+}
+
+// keyOf returns the key of data, as the one key of a multi index
+func (t *OrderedIndex[T, K]) keyOf(data T) []K {
+	return []K{t.Extractor(data)}
+}
+
+func (t *OrderedIndex[T, K]) add(fileID string, data T) {
+	t.keys.add(t.Extractor(data), fileID)
+}
+
+func (t *OrderedIndex[T, K]) remove(fileID string, data T) {
+	t.keys.remove(t.Extractor(data), fileID)
+}
+
+func (t *OrderedIndex[T, K]) checkWrites(changes []recordChange[T]) error {
+	if !t.unique {
+		return nil
+	}
+
+	return checkUnique(t.Name, changes, t.keyOf, func(key K) []string {
+		if n := t.keys.get(key); n != nil {
+			return n.fileIDs
+		}
+		return nil
+	})
+}
+
+// save writes the index the same way as an Index, it is only kept differently in memory
+func (t *OrderedIndex[T, K]) save() error {
+	return t.saveSnapshot(t.Collection.state.store.version(), func(version uint64) any {
+		snapshot := indexSnapshot[K]{Kind: t.kind, Version: version, Index: make(map[K][]string, t.keys.len)}
+		for n := t.keys.first(); n != nil; n = n.next[0] {
+			snapshot.Index[n.key] = n.fileIDs
+		}
+		return snapshot
+	})
+}
+
+func (t *OrderedIndex[T, K]) drop() {
+	t.dropped = true
+	t.keys = newSkipList[K]()
+}
@@ -0,0 +1,129 @@
+package gobble
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestSkipList(t *testing.T) {
+	list := newSkipList[int]()
+	model := map[int][]string{}
+
+	for i := 0; i < 5000; i++ {
+		key := rand.IntN(300)
+		fileID := fmt.Sprint(rand.IntN(20))
+		if rand.IntN(3) == 0 {
+			list.remove(key, fileID)
+			if j := slices.Index(model[key], fileID); j >= 0 {
+				model[key] = slices.Delete(model[key], j, j+1)
+			}
+			if len(model[key]) == 0 {
+				delete(model, key)
+			}
+		} else {
+			list.add(key, fileID)
+			model[key] = append(model[key], fileID)
+		}
+	}
+
+	var keys []int
+	for key := range model {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	if list.len != len(keys) {
+		t.Fatalf("expected %d keys, got %d", len(keys), list.len)
+	}
+	i := 0
+	for n := list.first(); n != nil; n = n.next[0] {
+		if n.key != keys[i] || !slices.Equal(n.fileIDs, model[n.key]) {
+			t.Fatalf("unexpected node %d %v, expected %d %v", n.key, n.fileIDs, keys[i], model[keys[i]])
+		}
+		i++
+	}
+	i = len(keys) - 1
+	for n := list.tail; n != nil; n = n.prev {
+		if n.key != keys[i] {
+			t.Fatalf("unexpected node %d going backwards, expected %d", n.key, keys[i])
+		}
+		i--
+	}
+	if n := list.seek(150); n != nil && n.key < 150 {
+		t.Fatalf("seek returned key %d", n.key)
+	}
+}
+
+func TestOrderedIndex(t *testing.T) {
+	db, _ := OpenDB("testdb-ordered")
+	defer func() {
+		_ = os.RemoveAll("testdb-ordered")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+
+	for i := 0; i < 50; i++ {
+		_ = c.Insert(ExamplePersonStruct{Name: fmt.Sprintf("ExamplePersonStruct %d", i), Age: (i * 7) % 50})
+	}
+
+	byAge, err := OpenOrderedIndex[ExamplePersonStruct, int](&c, "age", func(p ExamplePersonStruct) int { return p.Age })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, _ := byAge.Range(18, 30, 0)
+	if len(x) != 13 {
+		t.Fatalf("expected 13 records from 18 to 30, got %d", len(x))
+	}
+	for i := range x {
+		if x[i].Age != 18+i {
+			t.Fatalf("range not in key order: %v", x)
+		}
+	}
+	if x, _ := byAge.Range(18, 30, 5); len(x) != 5 || x[4].Age != 22 {
+		t.Fatalf("unexpected limited range %v", x)
+	}
+
+	// Maintained by writes through the collection
+	_ = c.Delete(func(p ExamplePersonStruct) bool { return p.Age < 5 })
+	_ = c.Modify(func(p ExamplePersonStruct) bool { return p.Age == 49 }, func(p ExamplePersonStruct) ExamplePersonStruct {
+		p.Age = 100
+		return p
+	})
+	if p, _ := byAge.Min(); p.Age != 5 {
+		t.Fatalf("expected min age 5, got %d", p.Age)
+	}
+	if p, _ := byAge.Max(); p.Age != 100 {
+		t.Fatalf("expected max age 100, got %d", p.Age)
+	}
+
+	var ages []int
+	_ = byAge.Descend(func(age int, p ExamplePersonStruct) bool {
+		ages = append(ages, age)
+		return len(ages) < 3
+	})
+	if !slices.Equal(ages, []int{100, 48, 47}) {
+		t.Fatalf("unexpected descending ages %v", ages)
+	}
+	ages = nil
+	_ = byAge.Ascend(func(age int, p ExamplePersonStruct) bool {
+		ages = append(ages, age)
+		return true
+	})
+	if len(ages) != 45 || !slices.IsSorted(ages) {
+		t.Fatalf("unexpected ascending ages %v", ages)
+	}
+
+	_ = db.Close()
+
+	// Loaded back in order
+	db, _ = OpenDB("testdb-ordered")
+	c, _ = OpenCollection[ExamplePersonStruct](db, "testcollection")
+	byAge, _ = OpenOrderedIndex[ExamplePersonStruct, int](&c, "age", func(p ExamplePersonStruct) int { return p.Age })
+	if x, _ := byAge.Range(0, 10, 0); len(x) != 6 || x[0].Age != 5 {
+		t.Fatalf("unexpected range after reopening %v", x)
+	}
+	_ = db.Close()
+}
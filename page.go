@@ -0,0 +1,326 @@
+package gobble
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"slices"
+	"strconv"
+)
+
+// Pagination
+//
+// A page ends with a cursor for the next one, which holds the ID (and for ordered and prefix indices, the key) of the
+// last record of the page. The next page starts right after that record, so records inserted or deleted meanwhile
+// don't shift pages the way an Offset would. The cursor is opaque, it is only meant to be passed back to the same
+// function with the same arguments.
+
+// ErrInvalidCursor is returned when a cursor passed to a Page function wasn't returned by it.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+var errPageSize = errors.New("page size must be more than 0")
+
+type keyCursor[K any] struct {
+	Key K
+	ID  int
+}
+
+func encodeIDCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+// decodeIDCursor returns the ID held by cursor, 0 for an empty one
+func decodeIDCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+
+	return id, nil
+}
+
+func encodeKeyCursor[K any](key K, id int) (string, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(keyCursor[K]{Key: key, ID: id}); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decodeKeyCursor returns the key and ID held by cursor, and false for an empty one
+func decodeKeyCursor[K any](cursor string) (keyCursor[K], bool, error) {
+	var c keyCursor[K]
+	if cursor == "" {
+		return c, false, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, false, ErrInvalidCursor
+	}
+	dec := gob.NewDecoder(bytes.NewReader(b))
+	if err := dec.Decode(&c); err != nil {
+		return c, false, ErrInvalidCursor
+	}
+
+	return c, true, nil
+}
+
+// idsAfter returns the IDs in fileIDs larger than after, in ascending order
+func idsAfter(fileIDs []string, after int) ([]int, error) {
+	ids := make([]int, 0, len(fileIDs))
+	for _, fileID := range fileIDs {
+		id, err := strconv.Atoi(fileID)
+		if err != nil {
+			return nil, err
+		}
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+// Page returns up to n records that query returns true for in ID order, starting after cursor (or from the first
+// record if it is empty), and the cursor for the next page, which is empty if there are no more records.
+func (t *Collection[T]) Page(query Query[T], cursor string, n int) ([]T, string, error) {
+	t.state.mu.RLock()
+	defer t.state.mu.RUnlock()
+
+	if n <= 0 {
+		return nil, "", errPageSize
+	}
+
+	after, err := decodeIDCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids, err := t.state.store.ids()
+	if err != nil {
+		return nil, "", err
+	}
+
+	start, _ := slices.BinarySearch(ids, after+1)
+
+	results := []T{}
+	last := 0
+	for _, id := range ids[start:] {
+		data, err := t.read(id)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if query(data) {
+			if len(results) == n {
+				return results, encodeIDCursor(last), nil
+			}
+			results = append(results, data)
+			last = id
+		}
+	}
+
+	return results, "", nil
+}
+
+// pageIDs reads up to n of the records with ids (ascending and after the cursor), and returns the cursor for the next
+// page
+func (t *Collection[T]) pageIDs(ids []int, n int) ([]T, string, error) {
+	results := []T{}
+	for i, id := range ids {
+		if i == n {
+			return results, encodeIDCursor(ids[i-1]), nil
+		}
+
+		data, err := t.read(id)
+		if err != nil {
+			return nil, "", err
+		}
+		results = append(results, data)
+	}
+
+	return results, "", nil
+}
+
+// Page returns up to n of the records with key in ID order, starting after cursor, like Collection.Page
+func (t *Index[T, D]) Page(key D, cursor string, n int) ([]T, string, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return nil, "", err
+	}
+	if n <= 0 {
+		return nil, "", errPageSize
+	}
+
+	after, err := decodeIDCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids, err := idsAfter(t.Index[key], after)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return t.Collection.pageIDs(ids, n)
+}
+
+// Page returns up to n of the records listed under key in ID order, starting after cursor, like Collection.Page
+func (t *MultiIndex[T, K]) Page(key K, cursor string, n int) ([]T, string, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return nil, "", err
+	}
+	if n <= 0 {
+		return nil, "", errPageSize
+	}
+
+	after, err := decodeIDCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids, err := idsAfter(t.Index[key], after)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return t.Collection.pageIDs(ids, n)
+}
+
+// keyPage collects a page of records in key order, and then ID order for records with the same key
+type keyPage[T any, K cmp.Ordered] struct {
+	c       *Collection[T]
+	cursor  keyCursor[K]
+	started bool // whether there was a cursor
+	n       int
+
+	results []T
+	last    keyCursor[K]
+	next    string
+}
+
+func newKeyPage[T any, K cmp.Ordered](c *Collection[T], cursor string, n int) (*keyPage[T, K], error) {
+	kc, started, err := decodeKeyCursor[K](cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyPage[T, K]{c: c, cursor: kc, started: started, n: n, results: []T{}}, nil
+}
+
+// add adds the records with key to the page, and returns false once it is full
+func (t *keyPage[T, K]) add(key K, fileIDs []string) (bool, error) {
+	after := 0
+	if t.started {
+		switch cmp.Compare(key, t.cursor.Key) {
+		case -1:
+			return true, nil
+		case 0:
+			after = t.cursor.ID
+		}
+	}
+
+	ids, err := idsAfter(fileIDs, after)
+	if err != nil {
+		return false, err
+	}
+
+	for _, id := range ids {
+		if len(t.results) == t.n {
+			t.next, err = encodeKeyCursor(t.last.Key, t.last.ID)
+			return false, err
+		}
+
+		data, err := t.c.read(id)
+		if err != nil {
+			return false, err
+		}
+		t.results = append(t.results, data)
+		t.last = keyCursor[K]{Key: key, ID: id}
+	}
+
+	return true, nil
+}
+
+// Page returns up to n of the records with a key from lo to hi (both included) in key order, starting after cursor,
+// like Collection.Page. Records with the same key are in ID order.
+func (t *OrderedIndex[T, K]) Page(lo, hi K, cursor string, n int) ([]T, string, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return nil, "", err
+	}
+	if n <= 0 {
+		return nil, "", errPageSize
+	}
+
+	page, err := newKeyPage[T, K](t.Collection, cursor, n)
+	if err != nil {
+		return nil, "", err
+	}
+
+	start := lo
+	if page.started && cmp.Less(lo, page.cursor.Key) {
+		start = page.cursor.Key
+	}
+
+	for node := t.keys.seek(start); node != nil && cmp.Compare(node.key, hi) <= 0; node = node.next[0] {
+		if ok, err := page.add(node.key, node.fileIDs); err != nil {
+			return nil, "", err
+		} else if !ok {
+			break
+		}
+	}
+
+	return page.results, page.next, nil
+}
+
+// Page returns up to n of the records whose key starts with prefix in key order, starting after cursor, like
+// Collection.Page. Records with the same key are in ID order.
+func (t *PrefixIndex[T]) Page(prefix string, cursor string, n int) ([]T, string, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return nil, "", err
+	}
+	if n <= 0 {
+		return nil, "", errPageSize
+	}
+
+	page, err := newKeyPage[T, string](t.Collection, cursor, n)
+	if err != nil {
+		return nil, "", err
+	}
+
+	t.keys.walk(t.fold(prefix), func(key string, fileIDs []string) bool {
+		var ok bool
+		ok, err = page.add(key, fileIDs)
+		return ok && err == nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return page.results, page.next, nil
+}
@@ -0,0 +1,69 @@
+package gobble
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPage(t *testing.T) {
+	db, _ := OpenDB("testdb-page")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-page")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	for i := 0; i < 10; i++ {
+		_ = c.Insert(ExamplePersonStruct{Name: fmt.Sprintf("Person %d", i), Age: i % 3})
+	}
+	even := func(p ExamplePersonStruct) bool { return p.Age != 1 }
+
+	x, cursor, err := c.Page(even, "", 3)
+	if err != nil || len(x) != 3 || x[2].Name != "Person 3" || cursor == "" {
+		t.Fatalf("unexpected first page %v %q %v", x, cursor, err)
+	}
+
+	// Records inserted and deleted before the cursor don't shift the next page
+	_ = c.DeleteByID(1)
+	_ = c.Insert(ExamplePersonStruct{Name: "Person 10", Age: 0})
+	x, cursor, _ = c.Page(even, cursor, 3)
+	if len(x) != 3 || x[0].Name != "Person 5" || x[2].Name != "Person 8" {
+		t.Fatalf("unexpected second page %v", x)
+	}
+	x, cursor, _ = c.Page(even, cursor, 3)
+	if len(x) != 2 || x[1].Name != "Person 10" || cursor != "" {
+		t.Fatalf("unexpected last page %v %q", x, cursor)
+	}
+	if _, _, err := c.Page(even, "garbage!", 3); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+
+	byAge, _ := OpenOrderedIndex[ExamplePersonStruct, int](&c, "age", func(p ExamplePersonStruct) int { return p.Age })
+	var names []string
+	for cursor := ""; ; {
+		x, next, err := byAge.Page(0, 1, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range x {
+			names = append(names, p.Name)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if fmt.Sprint(names) != "[Person 3 Person 6 Person 9 Person 10 Person 1 Person 4 Person 7]" {
+		t.Fatalf("unexpected records in key order %v", names)
+	}
+
+	byAgeHash, _ := OpenIndex[ExamplePersonStruct, int](&c, "age-hash", func(p ExamplePersonStruct) int { return p.Age })
+	x, cursor, _ = byAgeHash.Page(2, "", 2)
+	if len(x) != 2 || x[1].Name != "Person 5" {
+		t.Fatalf("unexpected index page %v", x)
+	}
+	if x, cursor, _ = byAgeHash.Page(2, cursor, 2); len(x) != 1 || x[0].Name != "Person 8" || cursor != "" {
+		t.Fatalf("unexpected last index page %v %q", x, cursor)
+	}
+}
@@ -0,0 +1,232 @@
+package gobble
+
+import (
+	"strings"
+)
+
+// PrefixIndex is an index with string keys kept in a trie, so besides looking up a key it can find the records whose
+// key starts with a prefix, in key order. It is safe for concurrent use like its collection.
+type PrefixIndex[T any] struct {
+	Collection *Collection[T]
+	Name       string
+	Extractor  func(T) string
+
+	keys     *trie
+	foldCase bool
+	unique   bool
+	indexFile
+}
+
+// WithCaseFolding makes a PrefixIndex ignore case, in its keys and in the keys and prefixes it is asked for.
+func WithCaseFolding() IndexOption {
+	return func(o *indexOptions) {
+		o.foldCase = true
+	}
+}
+
+// OpenPrefixIndex is OpenIndex for a PrefixIndex.
+func OpenPrefixIndex[T any](c *Collection[T], name string, extractor func(T) string, opts ...IndexOption) (*PrefixIndex[T], error) {
+	o := indexOptionsOf(opts)
+
+	return openIndex(c, name, func(index *PrefixIndex[T]) bool {
+		return index.unique == o.unique && index.foldCase == o.foldCase
+	}, func() (*PrefixIndex[T], error) {
+		i := &PrefixIndex[T]{Collection: c, Name: name, Extractor: extractor, keys: newTrie(), foldCase: o.foldCase, unique: o.unique}
+
+		kind := kindPrefix
+		if o.foldCase {
+			kind = kindPrefixFolded
+		}
+
+		index, file, err := loadKeys(c, name, kind, i.keyOf, o.unique)
+		if err != nil {
+			return nil, err
+		}
+		for key, fileIDs := range index {
+			for _, fileID := range fileIDs {
+				i.keys.add(key, fileID)
+			}
+		}
+
+		i.indexFile = file
+		return i, nil
+	})
+}
+
+// Get returns the records with key, like Index.Get
+func (t *PrefixIndex[T]) Get(key string) ([]T, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
+	fileIDs := t.keys.get(t.fold(key))
+	if len(fileIDs) == 0 {
+		return []T{}, nil
+	}
+
+	return t.Collection.readFileIDs(fileIDs)
+}
+
+func (t *PrefixIndex[T]) Num(key string) (int, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return 0, err
+	}
+
+	return len(t.keys.get(t.fold(key))), nil
+}
+
+// Prefix returns the records whose key starts with prefix, in key order. If limit is more than 0, at most that many
+// are returned.
+func (t *PrefixIndex[T]) Prefix(prefix string, limit int) ([]T, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
+	results := []T{}
+	var err error
+	t.keys.walk(t.fold(prefix), func(key string, fileIDs []string) bool {
+		for _, fileID := range fileIDs {
+			if limit > 0 && len(results) >= limit {
+				return false
+			}
+
+			var data T
+			if data, err = t.Collection.readFileID(fileID); err != nil {
+				return false
+			}
+			results = append(results, data)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// PrefixKeys returns the keys that start with prefix in order (folded if the index ignores case), for example to
+// suggest completions. If limit is more than 0, at most that many are returned.
+func (t *PrefixIndex[T]) PrefixKeys(prefix string, limit int) ([]string, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	t.keys.walk(t.fold(prefix), func(key string, fileIDs []string) bool {
+		if limit > 0 && len(keys) >= limit {
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	})
+
+	return keys, nil
+}
+
+func (t *PrefixIndex[T]) collection() *Collection[T] {
+	return t.Collection
+}
+
+func (t *PrefixIndex[T]) indexName() string {
+	return t.Name
+}
+
+func (t *PrefixIndex[T]) scan(op Op, key string, fn func(fileIDs []string) bool) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	switch op {
+	case Eq:
+		if fileIDs := t.keys.get(t.fold(key)); len(fileIDs) > 0 {
+			fn(fileIDs)
+		}
+	case HasPrefix:
+		t.keys.walk(t.fold(key), func(_ string, fileIDs []string) bool {
+			return fn(fileIDs)
+		})
+	default:
+		return unsupportedOp(t.Name, op)
+	}
+
+	return nil
+}
+
+func (t *PrefixIndex[T]) match(op Op, key string, data T) bool {
+	if op == HasPrefix {
+		return strings.HasPrefix(t.key(data), t.fold(key))
+	}
+	return t.key(data) == t.fold(key)
+}
+
+func (t *PrefixIndex[T]) groups(fn func(key string, fileIDs []string) bool) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	t.keys.walk("", fn)
+	return nil
+}
+
+func (t *PrefixIndex[T]) fold(key string) string {
+	if t.foldCase {
+		return strings.ToLower(key)
+	}
+	return key
+}
+
+// key returns the key of data as kept in the trie
+func (t *PrefixIndex[T]) key(data T) string {
+	return t.fold(t.Extractor(data))
+}
+
+// keyOf returns the folded key of data, as the one key of a multi index
+func (t *PrefixIndex[T]) keyOf(data T) []string {
+	return []string{t.key(data)}
+}
+
+func (t *PrefixIndex[T]) add(fileID string, data T) {
+	t.keys.add(t.key(data), fileID)
+}
+
+func (t *PrefixIndex[T]) remove(fileID string, data T) {
+	t.keys.remove(t.key(data), fileID)
+}
+
+func (t *PrefixIndex[T]) checkWrites(changes []recordChange[T]) error {
+	if !t.unique {
+		return nil
+	}
+
+	return checkUnique(t.Name, changes, t.keyOf, t.keys.get)
+}
+
+// save writes the index the same way as an Index, with the keys already folded
+func (t *PrefixIndex[T]) save() error {
+	return t.saveSnapshot(t.Collection.state.store.version(), func(version uint64) any {
+		snapshot := indexSnapshot[string]{Kind: t.kind, Version: version, Index: make(map[string][]string, t.keys.len)}
+		t.keys.walk("", func(key string, fileIDs []string) bool {
+			snapshot.Index[key] = fileIDs
+			return true
+		})
+		return snapshot
+	})
+}
+
+func (t *PrefixIndex[T]) drop() {
+	t.dropped = true
+	t.keys = newTrie()
+}
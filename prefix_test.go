@@ -0,0 +1,115 @@
+package gobble
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTrie(t *testing.T) {
+	keys := newTrie()
+	model := map[string][]string{}
+
+	for i := 0; i < 5000; i++ {
+		key := strings.Repeat("ab", rand.IntN(3)) + fmt.Sprint(rand.IntN(100))
+		fileID := fmt.Sprint(rand.IntN(20))
+		if rand.IntN(3) == 0 {
+			keys.remove(key, fileID)
+			if j := slices.Index(model[key], fileID); j >= 0 {
+				model[key] = slices.Delete(model[key], j, j+1)
+			}
+			if len(model[key]) == 0 {
+				delete(model, key)
+			}
+		} else {
+			keys.add(key, fileID)
+			model[key] = append(model[key], fileID)
+		}
+	}
+
+	var expected []string
+	for key := range model {
+		if strings.HasPrefix(key, "ab1") {
+			expected = append(expected, key)
+		}
+	}
+	slices.Sort(expected)
+
+	var walked []string
+	keys.walk("ab1", func(key string, fileIDs []string) bool {
+		if !slices.Equal(fileIDs, model[key]) {
+			t.Fatalf("unexpected file IDs %v for %q, expected %v", fileIDs, key, model[key])
+		}
+		walked = append(walked, key)
+		return true
+	})
+	if !slices.Equal(walked, expected) {
+		t.Fatalf("unexpected keys %v, expected %v", walked, expected)
+	}
+	if keys.len != len(model) {
+		t.Fatalf("expected %d keys, got %d", len(model), keys.len)
+	}
+
+	for key := range model {
+		for _, fileID := range slices.Clone(model[key]) {
+			keys.remove(key, fileID)
+		}
+	}
+	if keys.len != 0 || len(keys.root.children) != 0 {
+		t.Fatal("nodes left after removing every key")
+	}
+}
+
+func TestPrefixIndex(t *testing.T) {
+	db, _ := OpenDB("testdb-prefix")
+	defer func() {
+		_ = os.RemoveAll("testdb-prefix")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	for _, name := range []string{"John", "joanna", "Jo", "Bob", "Jonas", "Jack"} {
+		_ = c.Insert(ExamplePersonStruct{Name: name})
+	}
+
+	byName, err := OpenPrefixIndex[ExamplePersonStruct](&c, "name", func(p ExamplePersonStruct) string { return p.Name }, WithCaseFolding())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func(ps []ExamplePersonStruct) string {
+		var s []string
+		for _, p := range ps {
+			s = append(s, p.Name)
+		}
+		return strings.Join(s, ",")
+	}
+
+	if x, _ := byName.Prefix("JO", 0); names(x) != "Jo,joanna,John,Jonas" {
+		t.Fatalf("unexpected records for JO: %v", names(x))
+	}
+	if x, _ := byName.Prefix("jo", 2); names(x) != "Jo,joanna" {
+		t.Fatalf("unexpected limited records: %v", names(x))
+	}
+	if keys, _ := byName.PrefixKeys("j", 0); !slices.Equal(keys, []string{"jack", "jo", "joanna", "john", "jonas"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	_ = c.Delete(func(p ExamplePersonStruct) bool { return p.Name == "John" })
+	_ = c.Modify(func(p ExamplePersonStruct) bool { return p.Name == "Bob" }, func(p ExamplePersonStruct) ExamplePersonStruct {
+		p.Name = "Jon"
+		return p
+	})
+	if x, _ := byName.Prefix("jo", 0); names(x) != "Jo,joanna,Jon,Jonas" {
+		t.Fatalf("unexpected records after writes: %v", names(x))
+	}
+	_ = db.Close()
+
+	db, _ = OpenDB("testdb-prefix")
+	c, _ = OpenCollection[ExamplePersonStruct](db, "testcollection")
+	byName, _ = OpenPrefixIndex[ExamplePersonStruct](&c, "name", func(p ExamplePersonStruct) string { return p.Name }, WithCaseFolding())
+	if x, _ := byName.Get("JON"); names(x) != "Jon" {
+		t.Fatalf("unexpected records after reopening: %v", names(x))
+	}
+	_ = db.Close()
+}
@@ -0,0 +1,456 @@
+package gobble
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Structured queries
+//
+// A Query[T] is a function, so Select has to decode every record to run it. An IndexQuery is made of conditions on the
+// keys of indices instead, which Find can answer by looking up the IDs of the matching records in the indices, and only
+// decoding those.
+//
+// Find plans the query before running it. For an And it first counts the records each condition matches (counting
+// stops as soon as a condition matches more than the best one so far, so a range over most of an index is cheap to
+// rule out), looks up the condition matching the fewest records, and intersects its IDs with those of the conditions
+// matching at most intersectRatio times as many. The other conditions are checked on the decoded records, which is
+// cheaper than looking up a large set of IDs just to throw most of it away. An Or looks up all its conditions and
+// unions the IDs. Explain shows the plan.
+//
+// Every decoded record is checked against the whole query, so the result doesn't depend on the plan.
+
+// Op is how a condition of an IndexQuery compares the keys of an index with its value.
+type Op int
+
+const (
+	// Eq matches the records with the key, supported by all indices with keys
+	Eq Op = iota
+	// Lt matches the records with a smaller key, supported by OrderedIndex
+	Lt
+	// Le matches the records with a smaller or equal key, supported by OrderedIndex
+	Le
+	// Gt matches the records with a larger key, supported by OrderedIndex
+	Gt
+	// Ge matches the records with a larger or equal key, supported by OrderedIndex
+	Ge
+	// HasPrefix matches the records with a key starting with the value, supported by PrefixIndex
+	HasPrefix
+)
+
+func (o Op) String() string {
+	switch o {
+	case Eq:
+		return "="
+	case Lt:
+		return "<"
+	case Le:
+		return "<="
+	case Gt:
+		return ">"
+	case Ge:
+		return ">="
+	case HasPrefix:
+		return "has prefix"
+	default:
+		return "Op(" + strconv.Itoa(int(o)) + ")"
+	}
+}
+
+// intersectRatio is how many times more records than the condition that is looked up first another condition of an
+// And can match, and still be looked up instead of checked on the records
+const intersectRatio = 4
+
+// keyIndex is what an IndexQuery needs from an index with keys of type K
+type keyIndex[T any, K any] interface {
+	AnyIndex[T]
+	collection() *Collection[T]
+	indexName() string
+	// scan calls fn with the file IDs of every key matching op and key until it returns false, or returns an error if
+	// the index doesn't support op
+	scan(op Op, key K, fn func(fileIDs []string) bool) error
+	// match returns whether the key of data matches op and key
+	match(op Op, key K, data T) bool
+}
+
+// condition is a condition of an IndexQuery, without its key type
+type condition[T any] interface {
+	collection() *Collection[T]
+	String() string
+	scan(fn func(fileIDs []string) bool) error
+	match(data T) bool
+}
+
+type keyCondition[T any, K any] struct {
+	index keyIndex[T, K]
+	op    Op
+	key   K
+}
+
+func (t keyCondition[T, K]) collection() *Collection[T] {
+	return t.index.collection()
+}
+
+func (t keyCondition[T, K]) String() string {
+	return fmt.Sprintf("%q %s %v", t.index.indexName(), t.op, t.key)
+}
+
+func (t keyCondition[T, K]) scan(fn func(fileIDs []string) bool) error {
+	return t.index.scan(t.op, t.key, fn)
+}
+
+func (t keyCondition[T, K]) match(data T) bool {
+	return t.index.match(t.op, t.key, data)
+}
+
+// IndexQuery is a query made of conditions on the keys of indices of one collection, see Where and Collection.Find.
+// It is immutable, And, Or and Filter return new queries.
+type IndexQuery[T any] struct {
+	cond     condition[T] // set for a single condition
+	and      bool         // otherwise, whether the children must all match or only one of them
+	children []*IndexQuery[T]
+	filter   Query[T] // checked on the records as well, if set
+}
+
+// Where returns the query for the records whose key in index compares to key as op says, for example
+// Where(byAge, Ge, 18). The index must support op.
+func Where[T any, K any](index keyIndex[T, K], op Op, key K) *IndexQuery[T] {
+	return &IndexQuery[T]{cond: keyCondition[T, K]{index: index, op: op, key: key}}
+}
+
+// And returns the query for the records matching q and all of others
+func (q *IndexQuery[T]) And(others ...*IndexQuery[T]) *IndexQuery[T] {
+	return &IndexQuery[T]{and: true, children: append([]*IndexQuery[T]{q}, others...)}
+}
+
+// Or returns the query for the records matching q or any of others
+func (q *IndexQuery[T]) Or(others ...*IndexQuery[T]) *IndexQuery[T] {
+	return &IndexQuery[T]{children: append([]*IndexQuery[T]{q}, others...)}
+}
+
+// Filter returns the query for the records matching q that query also returns true for. The indices can't answer
+// query, so it is only run on the records q matches.
+func (q *IndexQuery[T]) Filter(query Query[T]) *IndexQuery[T] {
+	filtered := *q
+	if previous := q.filter; previous != nil {
+		filtered.filter = func(data T) bool { return previous(data) && query(data) }
+	} else {
+		filtered.filter = query
+	}
+	return &filtered
+}
+
+// match returns whether data matches the whole query
+func (q *IndexQuery[T]) match(data T) bool {
+	if q.filter != nil && !q.filter(data) {
+		return false
+	}
+	if q.cond != nil {
+		return q.cond.match(data)
+	}
+
+	for _, child := range q.children {
+		if child.match(data) != q.and {
+			return !q.and
+		}
+	}
+	return q.and
+}
+
+// conditions returns all the conditions of the query
+func (q *IndexQuery[T]) conditions() []condition[T] {
+	if q.cond != nil {
+		return []condition[T]{q.cond}
+	}
+
+	var conds []condition[T]
+	for _, child := range q.children {
+		conds = append(conds, child.conditions()...)
+	}
+	return conds
+}
+
+func (q *IndexQuery[T]) hasFilter() bool {
+	if q.filter != nil {
+		return true
+	}
+	for _, child := range q.children {
+		if child.hasFilter() {
+			return true
+		}
+	}
+	return false
+}
+
+// queryPlan is how the IDs of the records matching a query are looked up
+type queryPlan[T any] struct {
+	cond     condition[T]    // looked up, if set
+	children []*queryPlan[T] // otherwise, intersected (for an And) or unioned
+	and      bool
+	checked  []*IndexQuery[T] // children of an And that are only checked on the records
+
+	count int  // records the IDs looked up are for
+	more  bool // counting stopped early, there are more than count
+}
+
+// plan decides how to look up the query. Counting the records of a condition stops once there are more than limit
+// of them, if it is more than 0.
+func (q *IndexQuery[T]) plan(limit int) (*queryPlan[T], error) {
+	if q.cond != nil {
+		p := &queryPlan[T]{cond: q.cond}
+		err := q.cond.scan(func(fileIDs []string) bool {
+			p.count += len(fileIDs)
+			p.more = limit > 0 && p.count > limit
+			return !p.more
+		})
+		return p, err
+	}
+
+	if !q.and {
+		p := &queryPlan[T]{}
+		for _, child := range q.children {
+			c, err := child.plan(0)
+			if err != nil {
+				return nil, err
+			}
+			p.children = append(p.children, c)
+			p.count += c.count
+		}
+		return p, nil
+	}
+
+	// The single conditions first, they are the cheapest to count
+	children := slices.Clone(q.children)
+	slices.SortStableFunc(children, func(a, b *IndexQuery[T]) int {
+		return boolToInt(a.cond == nil) - boolToInt(b.cond == nil)
+	})
+
+	plans := make([]*queryPlan[T], len(children))
+	best := -1
+	for i, child := range children {
+		bound := limit
+		if best >= 0 {
+			bound = max(plans[best].count*intersectRatio, 1)
+		}
+
+		c, err := child.plan(bound)
+		if err != nil {
+			return nil, err
+		}
+		plans[i] = c
+		if !c.more && (best < 0 || c.count < plans[best].count) {
+			best = i
+		}
+	}
+	if best < 0 {
+		// Every condition matches more than limit records, the caller has a better plan
+		return &queryPlan[T]{and: true, children: plans[:1], checked: children[1:], count: plans[0].count, more: true}, nil
+	}
+
+	p := &queryPlan[T]{and: true, children: []*queryPlan[T]{plans[best]}, count: plans[best].count}
+	for i, c := range plans {
+		if i == best {
+			continue
+		}
+		if !c.more && c.count <= plans[best].count*intersectRatio {
+			p.children = append(p.children, c)
+		} else {
+			p.checked = append(p.checked, children[i])
+		}
+	}
+
+	return p, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// fileIDs looks up the IDs the plan is for
+func (p *queryPlan[T]) fileIDs() (map[string]bool, error) {
+	if p.cond != nil {
+		ids := make(map[string]bool, p.count)
+		err := p.cond.scan(func(fileIDs []string) bool {
+			for _, fileID := range fileIDs {
+				ids[fileID] = true
+			}
+			return true
+		})
+		return ids, err
+	}
+
+	ids, err := p.children[0].fileIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, child := range p.children[1:] {
+		other, err := child.fileIDs()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.and {
+			for fileID := range ids {
+				if !other[fileID] {
+					delete(ids, fileID)
+				}
+			}
+		} else {
+			for fileID := range other {
+				ids[fileID] = true
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// exact returns whether the IDs the plan looks up are exactly those of the records matching its conditions, because
+// none of them is left to be checked on the records
+func (p *queryPlan[T]) exact() bool {
+	if len(p.checked) > 0 {
+		return false
+	}
+	for _, child := range p.children {
+		if !child.exact() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *queryPlan[T]) explain(sb *strings.Builder, indent string) {
+	count := strconv.Itoa(p.count)
+	if p.more {
+		count = "more than " + count
+	}
+
+	switch {
+	case p.cond != nil:
+		fmt.Fprintf(sb, "%slookup %s: %s records\n", indent, p.cond, count)
+	case len(p.children) == 1:
+		p.children[0].explain(sb, indent)
+	case p.and:
+		fmt.Fprintf(sb, "%sintersect: at most %s records\n", indent, count)
+	default:
+		fmt.Fprintf(sb, "%sunion: at most %s records\n", indent, count)
+	}
+
+	if len(p.children) > 1 {
+		for _, child := range p.children {
+			child.explain(sb, indent+"  ")
+		}
+	}
+	for _, q := range p.checked {
+		for _, cond := range q.conditions() {
+			fmt.Fprintf(sb, "%scheck %s on the records\n", indent, cond)
+		}
+	}
+}
+
+// checkIndexQuery returns an error if the query uses indices of another collection
+func (t *Collection[T]) checkIndexQuery(q *IndexQuery[T]) error {
+	for _, cond := range q.conditions() {
+		if cond.collection().state != t.state {
+			return fmt.Errorf("query uses an index of another collection")
+		}
+	}
+	return nil
+}
+
+// Find returns the records matching q, in ID order unless sorted like with Select. Only the records the indices of q
+// find are decoded.
+func (t *Collection[T]) Find(q *IndexQuery[T], opts ...SelectOption) ([]T, error) {
+	t.state.mu.RLock()
+	defer t.state.mu.RUnlock()
+
+	s, err := newSelection[T](opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := t.findIDs(q)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		data, err := t.read(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if q.match(data) && !s.add(data) {
+			break
+		}
+	}
+
+	if results := s.get(); results != nil {
+		return results, nil
+	}
+	return []T{}, nil
+}
+
+// findIDs returns the IDs of the records the indices find for q in ascending order, they still have to be checked
+// with q.match. t.state.mu must be held.
+func (t *Collection[T]) findIDs(q *IndexQuery[T]) ([]int, error) {
+	if err := t.checkIndexQuery(q); err != nil {
+		return nil, err
+	}
+
+	p, err := q.plan(0)
+	if err != nil {
+		return nil, err
+	}
+
+	fileIDs, err := p.fileIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(fileIDs))
+	for fileID := range fileIDs {
+		id, err := strconv.Atoi(fileID)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+// Explain describes how Find would run q right now: which conditions it looks up in their indices, how many records
+// each of them matches, and what is checked on the records.
+func (t *Collection[T]) Explain(q *IndexQuery[T]) (string, error) {
+	t.state.mu.RLock()
+	defer t.state.mu.RUnlock()
+
+	if err := t.checkIndexQuery(q); err != nil {
+		return "", err
+	}
+
+	p, err := q.plan(0)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	p.explain(&sb, "")
+	if q.hasFilter() {
+		sb.WriteString("check filter functions on the records\n")
+	}
+
+	return sb.String(), nil
+}
+
+func unsupportedOp(index string, op Op) error {
+	return fmt.Errorf("index %q does not support %s", index, op)
+}
@@ -0,0 +1,65 @@
+package gobble
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestIndexQuery(t *testing.T) {
+	db, _ := OpenDB("testdb-query")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-query")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	for i := 0; i < 100; i++ {
+		_ = c.Insert(ExamplePersonStruct{Name: fmt.Sprintf("Person %d", i%10), Age: i})
+	}
+
+	byAge, _ := OpenOrderedIndex[ExamplePersonStruct, int](&c, "age", func(p ExamplePersonStruct) int { return p.Age })
+	byName, _ := OpenIndex[ExamplePersonStruct, string](&c, "name", func(p ExamplePersonStruct) string { return p.Name })
+	byPrefix, _ := OpenPrefixIndex[ExamplePersonStruct](&c, "prefix", func(p ExamplePersonStruct) string { return p.Name })
+
+	// The name matches 10 records, so it's looked up first, and the age range (90 records) is only checked
+	q := Where(byAge, Ge, 10).And(Where(byName, Eq, "Person 3"))
+	x, err := c.Find(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(x) != 9 || x[0].Age != 13 || x[8].Age != 93 {
+		t.Fatalf("unexpected records %v", x)
+	}
+	plan, _ := c.Explain(q)
+	if !strings.HasPrefix(plan, `lookup "name" = Person 3: 10 records`) || !strings.Contains(plan, `check "age" >= 10 on the records`) {
+		t.Fatalf("unexpected plan:\n%s", plan)
+	}
+
+	// A small range is intersected instead
+	q = Where(byAge, Lt, 20).And(Where(byName, Eq, "Person 3")).Filter(func(p ExamplePersonStruct) bool { return p.Age > 5 })
+	if x, _ := c.Find(q); len(x) != 1 || x[0].Age != 13 {
+		t.Fatalf("unexpected records %v", x)
+	}
+	plan, _ = c.Explain(q)
+	if !strings.HasPrefix(plan, "intersect") || !strings.Contains(plan, "check filter functions") {
+		t.Fatalf("unexpected plan:\n%s", plan)
+	}
+
+	q = Where(byAge, Gt, 97).Or(Where(byAge, Le, 1), Where(byPrefix, HasPrefix, "Person 5").And(Where(byAge, Lt, 30)))
+	if x, _ := c.Find(q); len(x) != 7 || x[0].Age != 0 || x[2].Age != 5 || x[6].Age != 99 {
+		t.Fatalf("unexpected records %v", x)
+	}
+	plan, _ = c.Explain(q)
+	if !strings.HasPrefix(plan, "union") {
+		t.Fatalf("unexpected plan:\n%s", plan)
+	}
+
+	if _, err := c.Find(Where(byName, Gt, "Person 3")); err == nil {
+		t.Fatal("unsupported op accepted")
+	}
+	other, _ := OpenCollection[ExamplePersonStruct](db, "othercollection")
+	if _, err := other.Find(Where(byName, Eq, "Person 3")); err == nil {
+		t.Fatal("index of another collection accepted")
+	}
+}
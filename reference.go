@@ -0,0 +1,352 @@
+package gobble
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// References
+//
+// A reference says that the records of a child collection refer to records of a parent collection by ID, like orders
+// refer to their customer. It is kept as an index of the child collection from parent IDs to the records referring to
+// them, and enforced by the deletes of the parent collection: Delete, DeleteByID, Index.Del, MultiIndex.Del and the
+// deletes of transactions. Inserts and updates of the child collection aren't checked, as that would lock the parent
+// collection on every write of the child. CheckReferences finds the records they left referring to nothing.
+//
+// A transaction applies the references to the records it deletes when it commits, locking the child collections like
+// any other collection it uses, and logs the deletes and updates of the records referring to them in its own batch. The
+// deletes of a collection other collections refer to run as transactions, so only transactions ever hold more than one
+// collection's lock, and a delete with all it does to other collections is applied together or not at all. They don't
+// wait for a running transaction, which may be the one calling them, and return ErrTxRunning instead.
+
+// RefAction says what deleting a record of the parent collection does to the records referring to it.
+type RefAction int
+
+const (
+	// Restrict refuses to delete a record other records refer to, with *ErrReferenced.
+	Restrict RefAction = iota
+	// Cascade deletes the records referring to a deleted record, and in turn the records referring to them.
+	Cascade
+	// SetNull clears the reference of the records referring to a deleted record.
+	SetNull
+)
+
+// ErrReferenced is returned by deletes refused by a Restrict reference. Nothing is deleted.
+type ErrReferenced struct {
+	Reference string // the child collection and name of the reference, like "orders.customer"
+	ID        int    // the record that was to be deleted
+	ChildID   int    // a record referring to it
+}
+
+func (e *ErrReferenced) Error() string {
+	return fmt.Sprintf("record %d is referred to by record %d through reference %q", e.ID, e.ChildID, e.Reference)
+}
+
+// DanglingReference is a record referring to a record that doesn't exist, see CheckReferences.
+type DanglingReference struct {
+	Reference string // the child collection and name of the reference, like "orders.customer"
+	ID        int    // the record of the child collection
+	ParentID  int    // the missing record of the parent collection
+}
+
+// Reference is a reference from the records of Child to the records of Parent, see OpenReference.
+type Reference[C any, P any] struct {
+	Name     string
+	Child    *Collection[C]
+	Parent   *Collection[P]
+	Index    *Index[C, int] // the records of Child by the ID of the record of Parent they refer to
+	OnDelete RefAction
+
+	clear Updater[C]
+}
+
+// reference is a Reference as seen by its parent collection, without knowing the type of its child collection
+type reference interface {
+	childState() *collectionState
+	indexName() string
+	// deleted does to the records referring to the parent records with ids what deleting them does, as writes of tx
+	deleted(tx *Tx, ids []int) error
+	// dangling returns the records referring to missing records, both collections must be locked
+	dangling() ([]DanglingReference, error)
+}
+
+// OpenReference declares that the records of child refer to records of parent by the ID parentID returns for them, 0
+// for none. The reference is kept in an index of child called name, which OpenIndex also returns. Like indices,
+// references have to be opened again every time the DB is, before deleting from parent. Dropping the index with
+// DropIndex drops the reference.
+//
+// onDelete says what deleting a record of parent does to the records referring to it. clear is what SetNull does to
+// them, usually setting to 0 the field parentID returns, and can be nil for the other actions.
+func OpenReference[C any, P any](child *Collection[C], name string, parent *Collection[P], parentID func(C) int, onDelete RefAction, clear Updater[C]) (*Reference[C, P], error) {
+	if onDelete < Restrict || onDelete > SetNull {
+		return nil, fmt.Errorf("unknown reference action %d", onDelete)
+	}
+	if onDelete == SetNull && clear == nil {
+		return nil, fmt.Errorf("SetNull reference needs a function to clear it")
+	}
+	if child.DB.state != parent.DB.state {
+		return nil, fmt.Errorf("collections are not from the same db")
+	}
+
+	index, err := OpenIndex(child, name, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	parent.state.mu.Lock()
+	defer parent.state.mu.Unlock()
+
+	key := child.Name + "." + name
+	if registered, ok := parent.state.refs[key]; ok {
+		ref, ok := registered.(*Reference[C, P])
+		if !ok {
+			return nil, fmt.Errorf("reference already open with different types")
+		}
+		if ref.OnDelete != onDelete {
+			return nil, fmt.Errorf("reference already open with a different action")
+		}
+		return ref, nil
+	}
+
+	ref := &Reference[C, P]{Name: name, Child: child, Parent: parent, Index: index, OnDelete: onDelete, clear: clear}
+	parent.state.refs[key] = ref
+	return ref, nil
+}
+
+func (t *Reference[C, P]) key() string {
+	return t.Child.Name + "." + t.Name
+}
+
+func (t *Reference[C, P]) childState() *collectionState {
+	return t.Child.state
+}
+
+func (t *Reference[C, P]) indexName() string {
+	return t.Index.Name
+}
+
+func (t *Reference[C, P]) deleted(tx *Tx, ids []int) error {
+	if err := t.Index.check(); err != nil {
+		return err
+	}
+
+	child := t.Child.Tx(tx)
+	if err := child.check(); err != nil {
+		return err
+	}
+
+	isDeleted := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		isDeleted[id] = true
+	}
+
+	// The records referring to them as stored, and any record the transaction wrote, which the index doesn't know yet
+	candidates := map[int]bool{}
+	for _, id := range ids {
+		for _, fileID := range t.Index.Index[id] {
+			childID, err := strconv.Atoi(fileID)
+			if err != nil {
+				return err
+			}
+			candidates[childID] = true
+		}
+	}
+	for childID, rec := range child.pending {
+		if !rec.deleted {
+			candidates[childID] = true
+		}
+	}
+
+	childIDs := make([]int, 0, len(candidates))
+	for childID := range candidates {
+		childIDs = append(childIDs, childID)
+	}
+	sort.Ints(childIDs)
+
+	for _, childID := range childIDs {
+		data, err := child.read(childID)
+		if errors.Is(err, ErrNotFound) {
+			// Deleted by the transaction already
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		id := t.Index.Extractor(data)
+		if !isDeleted[id] {
+			continue
+		}
+
+		switch t.OnDelete {
+		case Restrict:
+			return &ErrReferenced{Reference: t.key(), ID: id, ChildID: childID}
+		case Cascade:
+			child.pending[childID] = txRecord{deleted: true}
+		case SetNull:
+			if err := child.write(childID, t.clear(data)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *Reference[C, P]) dangling() ([]DanglingReference, error) {
+	if err := t.Index.check(); err != nil {
+		return nil, err
+	}
+
+	dangling := []DanglingReference{}
+	for parentID, fileIDs := range t.Index.Index {
+		if parentID == 0 {
+			continue
+		}
+
+		_, err := t.Parent.state.store.get(parentID)
+		if errors.Is(err, ErrNotFound) {
+			for _, fileID := range fileIDs {
+				id, err := strconv.Atoi(fileID)
+				if err != nil {
+					return nil, err
+				}
+				dangling = append(dangling, DanglingReference{Reference: t.key(), ID: id, ParentID: parentID})
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(dangling, func(i, j int) bool { return dangling[i].ID < dangling[j].ID })
+	return dangling, nil
+}
+
+// Dangling returns the records of the child collection referring to records the parent collection doesn't have, in ID
+// order.
+func (t *Reference[C, P]) Dangling() ([]DanglingReference, error) {
+	return danglingOf(t.Parent.state, t)
+}
+
+// CheckReferences returns the records referring to records that don't exist, through all the references open on the
+// collections of the DB, by reference and then in ID order.
+func (t *DB) CheckReferences() ([]DanglingReference, error) {
+	if t.state == nil {
+		return nil, fmt.Errorf("db was not opened with OpenDB")
+	}
+
+	t.state.mu.Lock()
+	parents := make([]*collectionState, 0, len(t.state.collections))
+	for _, state := range t.state.collections {
+		parents = append(parents, state)
+	}
+	t.state.mu.Unlock()
+
+	type namedRef struct {
+		key    string
+		parent *collectionState
+		ref    reference
+	}
+	var refs []namedRef
+	for _, parent := range parents {
+		parent.mu.RLock()
+		for key, ref := range parent.refs {
+			refs = append(refs, namedRef{key, parent, ref})
+		}
+		parent.mu.RUnlock()
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].key < refs[j].key })
+
+	dangling := []DanglingReference{}
+	for _, r := range refs {
+		found, err := danglingOf(r.parent, r.ref)
+		if err != nil {
+			return nil, err
+		}
+		dangling = append(dangling, found...)
+	}
+
+	return dangling, nil
+}
+
+// danglingOf locks the collections of ref for reading and returns its dangling references. It never waits for the child
+// collection while holding the parent, as a transaction may hold the child and be waiting for the parent.
+func danglingOf(parent *collectionState, ref reference) ([]DanglingReference, error) {
+	child := ref.childState()
+	for {
+		parent.mu.RLock()
+		if child == parent || child.mu.TryRLock() {
+			break
+		}
+		parent.mu.RUnlock()
+
+		child.mu.RLock()
+		child.mu.RUnlock()
+	}
+	defer parent.mu.RUnlock()
+	if child != parent {
+		defer child.mu.RUnlock()
+	}
+
+	return ref.dangling()
+}
+
+// dropReferences unregisters the references drop returns true for from their parent collections
+func (t *dbState) dropReferences(drop func(ref reference) bool) {
+	t.mu.Lock()
+	parents := make([]*collectionState, 0, len(t.collections))
+	for _, state := range t.collections {
+		parents = append(parents, state)
+	}
+	t.mu.Unlock()
+
+	for _, parent := range parents {
+		parent.mu.Lock()
+		for key, ref := range parent.refs {
+			if drop(ref) {
+				delete(parent.refs, key)
+			}
+		}
+		parent.mu.Unlock()
+	}
+}
+
+// referenced returns whether other collections refer to the collection's records, so deletes have to run as
+// transactions
+func (t *Collection[T]) referenced() bool {
+	t.state.mu.RLock()
+	defer t.state.mu.RUnlock()
+
+	return len(t.state.refs) > 0
+}
+
+// deleteReferenced deletes the records fileIDs returns in a transaction, which applies the references to them, or
+// returns ErrTxRunning if one is running already. fileIDs is called with the collection locked by the transaction.
+func (t *Collection[T]) deleteReferenced(fileIDs func() ([]string, error)) error {
+	return t.DB.update(func(tx *Tx) error {
+		tc := t.Tx(tx)
+		if err := tc.check(); err != nil {
+			return err
+		}
+
+		ids, err := fileIDs()
+		if err != nil {
+			return err
+		}
+
+		for _, fileID := range ids {
+			id, err := strconv.Atoi(fileID)
+			if err != nil {
+				return err
+			}
+
+			if _, err := tc.read(id); err != nil {
+				return err
+			}
+			tc.pending[id] = txRecord{deleted: true}
+		}
+
+		return nil
+	}, false)
+}
@@ -0,0 +1,245 @@
+package gobble
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+type exampleCustomerOrder struct {
+	Item     string
+	Customer int
+}
+
+type exampleOrderLine struct {
+	Order    int
+	Quantity int
+}
+
+func TestReference(t *testing.T) {
+	db, _ := OpenDB("testdb-reference")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-reference")
+	}()
+	customers, _ := OpenCollection[ExamplePersonStruct](db, "customers")
+	orders, _ := OpenCollection[exampleCustomerOrder](db, "orders")
+	lines, _ := OpenCollection[exampleOrderLine](db, "lines")
+
+	alice, _ := customers.InsertWithID(ExamplePersonStruct{Name: "Alice"})
+	bob, _ := customers.InsertWithID(ExamplePersonStruct{Name: "Bob"})
+	carol, _ := customers.InsertWithID(ExamplePersonStruct{Name: "Carol"})
+	book, _ := orders.InsertWithID(exampleCustomerOrder{Item: "book", Customer: alice})
+	_, _ = orders.InsertWithID(exampleCustomerOrder{Item: "pen", Customer: alice})
+	lamp, _ := orders.InsertWithID(exampleCustomerOrder{Item: "lamp", Customer: bob})
+	_ = lines.Insert(exampleOrderLine{Order: book, Quantity: 2})
+	_ = lines.Insert(exampleOrderLine{Order: lamp, Quantity: 1})
+
+	byCustomer, err := OpenReference(&orders, "customer", &customers, func(o exampleCustomerOrder) int { return o.Customer }, Restrict, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenReference(&lines, "order", &orders, func(l exampleOrderLine) int { return l.Order }, Cascade, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Restrict refuses the whole delete
+	var referenced *ErrReferenced
+	err = customers.Delete(func(p ExamplePersonStruct) bool { return true })
+	if !errors.As(err, &referenced) || referenced.ID != alice || referenced.Reference != "orders.customer" {
+		t.Fatalf("expected ErrReferenced for record %d, got %v", alice, err)
+	}
+	if n, _ := customers.Number(); n != 3 {
+		t.Fatalf("expected no customer to be deleted, got %d left", n)
+	}
+	if err := customers.DeleteByID(carol); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cascade deletes the lines of the deleted orders
+	if err := byCustomer.Index.Del(bob); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := lines.Number(); n != 1 {
+		t.Fatalf("expected 1 line left, got %d", n)
+	}
+
+	// SetNull clears the references instead, once opened again with it in a new DB
+	_ = db.Close()
+	db, _ = OpenDB("testdb-reference")
+	customers, _ = OpenCollection[ExamplePersonStruct](db, "customers")
+	orders, _ = OpenCollection[exampleCustomerOrder](db, "orders")
+	lines, _ = OpenCollection[exampleOrderLine](db, "lines")
+	_, err = OpenReference(&orders, "customer", &customers, func(o exampleCustomerOrder) int { return o.Customer }, SetNull,
+		func(o exampleCustomerOrder) exampleCustomerOrder { o.Customer = 0; return o })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := customers.DeleteByID(alice); err != nil {
+		t.Fatal(err)
+	}
+	remaining, _ := orders.Select(func(o exampleCustomerOrder) bool { return true })
+	if fmt.Sprint(remaining) != "[{book 0} {pen 0}]" {
+		t.Fatalf("unexpected orders %v", remaining)
+	}
+
+	// Writes to the child collection aren't checked, CheckReferences finds what they left behind
+	stray, _ := lines.InsertWithID(exampleOrderLine{Order: 99})
+	_, _ = OpenReference(&lines, "order", &orders, func(l exampleOrderLine) int { return l.Order }, Cascade, nil)
+	dangling, err := db.CheckReferences()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(dangling) != fmt.Sprint([]DanglingReference{{Reference: "lines.order", ID: stray, ParentID: 99}}) {
+		t.Fatalf("unexpected dangling references %v", dangling)
+	}
+}
+
+func TestSelfReference(t *testing.T) {
+	db, _ := OpenDB("testdb-self-reference")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-self-reference")
+	}()
+	people, _ := OpenCollection[ExamplePersonStruct](db, "people")
+
+	// Age is the ID of the person's manager here
+	boss, _ := people.InsertWithID(ExamplePersonStruct{Name: "Boss"})
+	manager, _ := people.InsertWithID(ExamplePersonStruct{Name: "Manager", Age: boss})
+	_ = people.Insert(ExamplePersonStruct{Name: "Employee", Age: manager})
+	other, _ := people.InsertWithID(ExamplePersonStruct{Name: "Other"})
+
+	ref, err := OpenReference(&people, "manager", &people, func(p ExamplePersonStruct) int { return p.Age }, Restrict, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting a manager along with everyone under them is allowed
+	if err := people.Delete(func(p ExamplePersonStruct) bool { return p.Name != "Other" }); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := people.Number(); n != 1 {
+		t.Fatalf("expected 1 person left, got %d", n)
+	}
+
+	if dangling, _ := ref.Dangling(); len(dangling) != 0 {
+		t.Fatalf("unexpected dangling references %v", dangling)
+	}
+	_ = people.ReplaceByID(other, ExamplePersonStruct{Name: "Other", Age: boss})
+	if dangling, _ := ref.Dangling(); len(dangling) != 1 || dangling[0].ID != other {
+		t.Fatalf("unexpected dangling references %v", dangling)
+	}
+}
+
+func TestReferenceInTx(t *testing.T) {
+	db, _ := OpenDB("testdb-reference-tx")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-reference-tx")
+	}()
+	customers, _ := OpenCollection[ExamplePersonStruct](db, "customers")
+	orders, _ := OpenCollection[exampleCustomerOrder](db, "orders")
+	lines, _ := OpenCollection[exampleOrderLine](db, "lines")
+
+	alice, _ := customers.InsertWithID(ExamplePersonStruct{Name: "Alice"})
+	bob, _ := customers.InsertWithID(ExamplePersonStruct{Name: "Bob"})
+	book, _ := orders.InsertWithID(exampleCustomerOrder{Item: "book", Customer: alice})
+	_ = lines.Insert(exampleOrderLine{Order: book, Quantity: 2})
+
+	_, err := OpenReference(&orders, "customer", &customers, func(o exampleCustomerOrder) int { return o.Customer }, Restrict, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenReference(&lines, "order", &orders, func(l exampleOrderLine) int { return l.Order }, Cascade, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Restrict refuses the commit, also for records the transaction wrote itself
+	var referenced *ErrReferenced
+	err = db.Update(func(tx *Tx) error {
+		return customers.Tx(tx).Delete(func(p ExamplePersonStruct) bool { return p.Name == "Alice" })
+	})
+	if !errors.As(err, &referenced) || referenced.ID != alice {
+		t.Fatalf("expected ErrReferenced for record %d, got %v", alice, err)
+	}
+	err = db.Update(func(tx *Tx) error {
+		if err := orders.Tx(tx).Insert(exampleCustomerOrder{Item: "lamp", Customer: bob}); err != nil {
+			return err
+		}
+		return customers.Tx(tx).Delete(func(p ExamplePersonStruct) bool { return p.Name == "Bob" })
+	})
+	if !errors.As(err, &referenced) || referenced.ID != bob {
+		t.Fatalf("expected ErrReferenced for record %d, got %v", bob, err)
+	}
+	if n, _ := customers.Number(); n != 2 {
+		t.Fatalf("expected no customer to be deleted, got %d left", n)
+	}
+	if n, _ := orders.Number(); n != 1 {
+		t.Fatalf("expected no order to be inserted, got %d", n)
+	}
+
+	// Deleting the orders with their customer is allowed, and cascades to their lines, which is replayed as one batch
+	err = db.Update(func(tx *Tx) error {
+		if err := orders.Tx(tx).Delete(func(o exampleCustomerOrder) bool { return o.Customer == alice }); err != nil {
+			return err
+		}
+		return customers.Tx(tx).Delete(func(p ExamplePersonStruct) bool { return p.Name == "Alice" })
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = db.Close()
+	db, _ = OpenDB("testdb-reference-tx")
+	customers, _ = OpenCollection[ExamplePersonStruct](db, "customers")
+	orders, _ = OpenCollection[exampleCustomerOrder](db, "orders")
+	lines, _ = OpenCollection[exampleOrderLine](db, "lines")
+	if n, _ := customers.Number(); n != 1 {
+		t.Fatalf("expected 1 customer left, got %d", n)
+	}
+	if n, _ := orders.Number(); n != 0 {
+		t.Fatalf("expected no order left, got %d", n)
+	}
+	if n, _ := lines.Number(); n != 0 {
+		t.Fatalf("expected the lines to be deleted with their order, got %d left", n)
+	}
+}
+
+func TestReferenceDeleteInsideUpdate(t *testing.T) {
+	db, _ := OpenDB("testdb-reference-update")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-reference-update")
+	}()
+	customers, _ := OpenCollection[ExamplePersonStruct](db, "customers")
+	orders, _ := OpenCollection[exampleCustomerOrder](db, "orders")
+
+	alice, _ := customers.InsertWithID(ExamplePersonStruct{Name: "Alice"})
+	_ = orders.Insert(exampleCustomerOrder{Item: "book", Customer: alice})
+	_, err := OpenReference(&orders, "customer", &customers, func(o exampleCustomerOrder) int { return o.Customer }, Restrict, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A delete that would wait for the transaction calling it fails instead
+	err = db.Update(func(tx *Tx) error {
+		return customers.DeleteByID(alice)
+	})
+	if !errors.Is(err, ErrTxRunning) {
+		t.Fatalf("expected ErrTxRunning, got %v", err)
+	}
+
+	// Dropping the index of the reference drops the reference
+	if err := orders.DropIndex("customer"); err != nil {
+		t.Fatal(err)
+	}
+	if err := customers.DeleteByID(alice); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := orders.Number(); n != 1 {
+		t.Fatalf("expected the order to be left alone, got %d orders", n)
+	}
+}
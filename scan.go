@@ -0,0 +1,113 @@
+package gobble
+
+import (
+	"errors"
+	"iter"
+	"slices"
+)
+
+// Iterators
+//
+// Scan and Iter decode one record at a time as the loop asks for it, so going through a large collection needs no
+// more memory than one record (and the list of IDs). They don't hold the collection's lock between records, so the
+// loop body can write to the collection. A record deleted before the loop gets to it is skipped, a record inserted
+// after the loop started isn't seen.
+
+// Scan returns an iterator over the records query returns true for, in ID order. If reading a record fails the
+// iterator yields the error and stops.
+//
+//	for person, err := range people.Scan(func(p Person) bool { return p.Age > 30 }) { ... }
+func (t *Collection[T]) Scan(query Query[T]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		t.state.mu.RLock()
+		ids, err := t.state.store.ids()
+		t.state.mu.RUnlock()
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+
+		for _, id := range ids {
+			data, err := t.GetByID(id)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+
+			if query(data) && !yield(data, nil) {
+				return
+			}
+		}
+	}
+}
+
+// scanFileIDs iterates over the records with the file IDs returned by fileIDs that match returns true for, like Scan.
+// fileIDs is called with t.state.mu held when the loop starts.
+func (t *Collection[T]) scanFileIDs(fileIDs func() ([]string, error), match func(T) bool) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		t.state.mu.RLock()
+		ids, err := fileIDs()
+		t.state.mu.RUnlock()
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+
+		sorted, err := idsAfter(ids, 0)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+
+		for _, id := range sorted {
+			data, err := t.GetByID(id)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+
+			// Modified since the file IDs were taken from an index
+			if !match(data) {
+				continue
+			}
+
+			if !yield(data, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Iter returns an iterator over the records with key in ID order, like Scan
+func (t *Index[T, D]) Iter(key D) iter.Seq2[T, error] {
+	return t.Collection.scanFileIDs(func() ([]string, error) {
+		if err := t.check(); err != nil {
+			return nil, err
+		}
+		return slices.Clone(t.Index[key]), nil
+	}, func(data T) bool {
+		return t.Extractor(data) == key
+	})
+}
+
+// Iter returns an iterator over the records listed under key in ID order, like Scan
+func (t *MultiIndex[T, K]) Iter(key K) iter.Seq2[T, error] {
+	return t.Collection.scanFileIDs(func() ([]string, error) {
+		if err := t.check(); err != nil {
+			return nil, err
+		}
+		return slices.Clone(t.Index[key]), nil
+	}, func(data T) bool {
+		return t.match(Eq, key, data)
+	})
+}
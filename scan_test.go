@@ -0,0 +1,72 @@
+package gobble
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	db, _ := OpenDB("testdb-scan")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-scan")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	for i := 0; i < 20; i++ {
+		_ = c.Insert(ExamplePersonStruct{Name: fmt.Sprintf("Person %d", i), Age: i % 4})
+	}
+
+	sum, n := 0, 0
+	for p, err := range c.Scan(func(p ExamplePersonStruct) bool { return p.Age > 1 }) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum += p.Age
+		n++
+	}
+	if n != 10 || sum != 25 {
+		t.Fatalf("expected 10 records with ages adding up to 25, got %d and %d", n, sum)
+	}
+
+	// Stopping early, and writing from the loop body
+	n = 0
+	for p, err := range c.Scan(func(p ExamplePersonStruct) bool { return true }) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			_ = c.Delete(func(q ExamplePersonStruct) bool { return q.Name == "Person 1" })
+		}
+		if p.Name == "Person 1" {
+			t.Fatal("deleted record yielded")
+		}
+		if n++; n == 5 {
+			break
+		}
+	}
+	if n != 5 {
+		t.Fatalf("expected to stop after 5 records, got %d", n)
+	}
+
+	byAge, _ := OpenIndex[ExamplePersonStruct, int](&c, "age", func(p ExamplePersonStruct) int { return p.Age })
+	var names []string
+	for p, err := range byAge.Iter(3) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, p.Name)
+		// Modified records that no longer have the key are skipped
+		_ = byAge.Mod(3, func(p ExamplePersonStruct) ExamplePersonStruct { p.Age = 0; return p })
+	}
+	if fmt.Sprint(names) != "[Person 3]" {
+		t.Fatalf("unexpected records %v", names)
+	}
+
+	_ = c.DropIndex("age")
+	for _, err := range byAge.Iter(0) {
+		if err == nil {
+			t.Fatal("dropped index iterated")
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package gobble
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Segment file format: a sequence of records, each a fixed size header followed by a payload
+//   op (1 byte) | record ID (8) | sequence number (8) | payload length (4) | CRC32 of header and payload (4) | payload
+// A put record's payload is the gob encoded struct, a delete record (tombstone) has no payload.
+// The sequence number increases with every write to the collection, so the newest version of a record wins
+// no matter which segment it ended up in.
+
+const (
+	segmentOpPut    byte = 1
+	segmentOpDelete byte = 2
+
+	segmentHeaderSize = 25
+)
+
+// errTornRecord means a segment ends in a record that was not completely written
+var errTornRecord = errors.New("incomplete record")
+
+type segmentLocation struct {
+	segment int
+	offset  int64 // of the payload
+	size    int
+	seq     uint64
+}
+
+// segmentStore is the SegmentStorage engine. Only the newest segment is appended to, older ones are only
+// replaced as a whole by compaction.
+type segmentStore struct {
+	dir       string
+	maxSize   int64
+	threshold float64 // dead space ratio that triggers a background compaction, 0 to disable
+	readOnly  bool
+
+	mu         sync.RWMutex
+	files      map[int]*os.File
+	active     int // number of the segment being appended to
+	activeSize int64
+	offsets    map[int]segmentLocation
+	lastID     int
+	seq        uint64
+	unsynced   map[int]bool // segments appended to since the last sync
+
+	totalBytes int64 // size of all segments
+	liveBytes  int64 // size of the records in offsets, everything else can be reclaimed by compaction
+
+	compacting bool
+	closing    bool // no new background compactions are started
+	closed     bool
+	compactErr error // from the last background compaction
+	wg         sync.WaitGroup
+}
+
+func openSegmentStore(dir string, o options) (*segmentStore, error) {
+	meta, err := readMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &segmentStore{
+		dir:       dir,
+		maxSize:   o.segmentSize,
+		threshold: o.compactionThreshold,
+		readOnly:  o.lockMode == LockShared,
+		files:     map[int]*os.File{},
+		offsets:   map[int]segmentLocation{},
+		lastID:    meta.LastID,
+		seq:       meta.Version,
+		unsynced:  map[int]bool{},
+	}
+
+	nums, err := segmentNumbers(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sequence numbers of the newest tombstone of each ID, so older puts replayed after them are ignored
+	deleted := map[int]uint64{}
+
+	flag := os.O_RDWR
+	if t.readOnly {
+		flag = os.O_RDONLY
+	}
+
+	for i, num := range nums {
+		f, err := os.OpenFile(t.segmentPath(num), flag, 0644)
+		if err != nil {
+			_ = t.close()
+			return nil, err
+		}
+		t.files[num] = f
+
+		end, err := t.load(f, num, deleted)
+		if errors.Is(err, errTornRecord) && i == len(nums)-1 {
+			// The process stopped in the middle of appending, that write never returned so it can be dropped
+			err = nil
+			if !t.readOnly {
+				err = f.Truncate(end)
+			}
+		}
+		if err != nil {
+			_ = t.close()
+			return nil, fmt.Errorf("segment %s: %w", t.segmentPath(num), err)
+		}
+
+		t.active = num
+		t.activeSize = end
+		t.totalBytes += end
+	}
+
+	for _, loc := range t.offsets {
+		t.liveBytes += segmentHeaderSize + int64(loc.size)
+	}
+
+	if !t.readOnly && (len(nums) == 0 || t.activeSize >= t.maxSize) {
+		if err := t.rotate(); err != nil {
+			_ = t.close()
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+func segmentNumbers(dir string) ([]int, error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer func(d *os.File) {
+		_ = d.Close()
+	}(d)
+
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var nums []int
+	for _, name := range names {
+		if !strings.HasPrefix(name, "s") || !strings.HasSuffix(name, ".seg") {
+			continue
+		}
+
+		num, err := strconv.Atoi(name[1 : len(name)-4])
+		if err != nil {
+			continue
+		}
+
+		nums = append(nums, num)
+	}
+
+	sort.Ints(nums)
+	return nums, nil
+}
+
+func (t *segmentStore) segmentPath(num int) string {
+	return t.dir + "/s" + strconv.Itoa(num) + ".seg"
+}
+
+// load replays the records of one segment into the offset table, and returns the offset after the last valid record
+func (t *segmentStore) load(f *os.File, num int, deleted map[int]uint64) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(f)
+	header := make([]byte, segmentHeaderSize)
+	var offset int64
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return offset, errTornRecord
+			}
+			return offset, err
+		}
+
+		op, id, seq, size := decodeSegmentHeader(header)
+		if offset+segmentHeaderSize+int64(size) > info.Size() {
+			return offset, errTornRecord
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return offset, errTornRecord
+		}
+
+		if segmentChecksum(header, payload) != binary.LittleEndian.Uint32(header[21:25]) {
+			return offset, errTornRecord
+		}
+
+		loc := segmentLocation{segment: num, offset: offset + segmentHeaderSize, size: size, seq: seq}
+
+		switch op {
+		case segmentOpPut:
+			cur, ok := t.offsets[id]
+			if seq > deleted[id] && (!ok || cur.seq < seq) {
+				t.offsets[id] = loc
+			}
+		case segmentOpDelete:
+			if cur, ok := t.offsets[id]; ok && cur.seq < seq {
+				delete(t.offsets, id)
+			}
+			if seq > deleted[id] {
+				deleted[id] = seq
+			}
+		default:
+			return offset, fmt.Errorf("unknown record type %d at offset %d", op, offset)
+		}
+
+		if id > t.lastID {
+			t.lastID = id
+		}
+		if seq > t.seq {
+			t.seq = seq
+		}
+
+		offset += segmentHeaderSize + int64(size)
+	}
+}
+
+func encodeSegmentRecord(op byte, id int, seq uint64, payload []byte) []byte {
+	rec := make([]byte, segmentHeaderSize+len(payload))
+	rec[0] = op
+	binary.LittleEndian.PutUint64(rec[1:9], uint64(id))
+	binary.LittleEndian.PutUint64(rec[9:17], seq)
+	binary.LittleEndian.PutUint32(rec[17:21], uint32(len(payload)))
+	copy(rec[segmentHeaderSize:], payload)
+	binary.LittleEndian.PutUint32(rec[21:25], segmentChecksum(rec[:segmentHeaderSize], payload))
+	return rec
+}
+
+func decodeSegmentHeader(header []byte) (op byte, id int, seq uint64, size int) {
+	return header[0],
+		int(binary.LittleEndian.Uint64(header[1:9])),
+		binary.LittleEndian.Uint64(header[9:17]),
+		int(binary.LittleEndian.Uint32(header[17:21]))
+}
+
+// segmentChecksum covers the header up to the checksum field, and the payload
+func segmentChecksum(header []byte, payload []byte) uint32 {
+	crc := crc32.ChecksumIEEE(header[:21])
+	return crc32.Update(crc, crc32.IEEETable, payload)
+}
+
+// rotate starts a new, empty active segment
+func (t *segmentStore) rotate() error {
+	// Only the newest segment may end in a record torn by a crash, so the one before it is synced first
+	if f, ok := t.files[t.active]; ok && t.unsynced[t.active] {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		delete(t.unsynced, t.active)
+	}
+
+	num := t.active + 1
+
+	f, err := os.OpenFile(t.segmentPath(num), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(t.dir); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	t.files[num] = f
+	t.active = num
+	t.activeSize = 0
+	return nil
+}
+
+// append writes one record to the end of the active segment, t.mu must be held
+func (t *segmentStore) append(op byte, id int, payload []byte) (segmentLocation, error) {
+	if t.activeSize >= t.maxSize {
+		if err := t.rotate(); err != nil {
+			return segmentLocation{}, err
+		}
+	}
+
+	seq := t.seq + 1
+	rec := encodeSegmentRecord(op, id, seq, payload)
+	f := t.files[t.active]
+
+	// If this fails part way, the next append overwrites the partial record since activeSize isn't advanced
+	if _, err := f.WriteAt(rec, t.activeSize); err != nil {
+		return segmentLocation{}, err
+	}
+	t.unsynced[t.active] = true
+
+	loc := segmentLocation{segment: t.active, offset: t.activeSize + segmentHeaderSize, size: len(payload), seq: seq}
+	t.seq = seq
+	t.activeSize += int64(len(rec))
+	t.totalBytes += int64(len(rec))
+	return loc, nil
+}
+
+func (t *segmentStore) nextID() (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastID++
+	return t.lastID, nil
+}
+
+func (t *segmentStore) get(id int) ([]byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	loc, ok := t.offsets[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	b := make([]byte, loc.size)
+	if _, err := t.files[loc.segment].ReadAt(b, loc.offset); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (t *segmentStore) put(id int, data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	loc, err := t.append(segmentOpPut, id, data)
+	if err != nil {
+		return err
+	}
+
+	if old, ok := t.offsets[id]; ok {
+		t.liveBytes -= segmentHeaderSize + int64(old.size)
+	}
+	t.liveBytes += segmentHeaderSize + int64(loc.size)
+
+	t.offsets[id] = loc
+	if id > t.lastID {
+		t.lastID = id
+	}
+
+	t.maybeCompact()
+	return nil
+}
+
+func (t *segmentStore) remove(id int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	old, ok := t.offsets[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	if _, err := t.append(segmentOpDelete, id, nil); err != nil {
+		return err
+	}
+
+	t.liveBytes -= segmentHeaderSize + int64(old.size)
+	delete(t.offsets, id)
+
+	t.maybeCompact()
+	return nil
+}
+
+func (t *segmentStore) ids() ([]int, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ids := make([]int, 0, len(t.offsets))
+	for id := range t.offsets {
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (t *segmentStore) count() (int, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return len(t.offsets), nil
+}
+
+// version is the sequence number of the last write
+func (t *segmentStore) version() uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.seq
+}
+
+func (t *segmentStore) sync() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for num := range t.unsynced {
+		if f, ok := t.files[num]; ok {
+			if err := f.Sync(); err != nil {
+				return err
+			}
+		}
+		delete(t.unsynced, num)
+	}
+
+	return nil
+}
+
+// close lets a pending background compaction finish first, and returns its error if it failed
+func (t *segmentStore) close() error {
+	t.mu.Lock()
+	t.closing = true
+	t.mu.Unlock()
+
+	t.wg.Wait()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+
+	firstErr := t.compactErr
+	for num, f := range t.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(t.files, num)
+	}
+
+	return firstErr
+}
@@ -0,0 +1,149 @@
+package gobble
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestSegmentStorage(t *testing.T) {
+	db, _ := OpenDB("testdb-seg", WithStorage(SegmentStorage), WithSegmentSize(512))
+	defer func() { _ = os.RemoveAll("testdb-seg") }()
+	c, err := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i1, _ := OpenIndex[ExamplePersonStruct, string](&c, "name", func(p ExamplePersonStruct) string {
+		return p.Name
+	})
+
+	for i := 1; i <= 20; i++ {
+		_ = c.Insert(ExamplePersonStruct{Name: fmt.Sprintf("ExamplePersonStruct %d", i), Age: i})
+	}
+	_ = c.Modify(func(p ExamplePersonStruct) bool { return p.Age == 1 }, func(p ExamplePersonStruct) ExamplePersonStruct { p.Age = 10; return p })
+	_ = c.Delete(func(p ExamplePersonStruct) bool { return p.Age > 15 })
+	_ = i1.Del("ExamplePersonStruct 2")
+
+	x, _ := i1.Get("ExamplePersonStruct 1")
+	if !verifyItemsEqual(x, []ExamplePersonStruct{{Name: "ExamplePersonStruct 1", Age: 10}}) {
+		t.Fatal("Modify not visible through index")
+	}
+	if n, _ := c.Number(); n != 14 {
+		t.Fatalf("expected 14 records, got %d", n)
+	}
+	if nums, _ := segmentNumbers("testdb-seg/testcollection"); len(nums) < 2 {
+		t.Fatal("expected writes to be spread over several segments")
+	}
+
+	// Simulate a crash in the middle of an append
+	_ = db.Close()
+	nums, _ := segmentNumbers("testdb-seg/testcollection")
+	last := "testdb-seg/testcollection/s" + fmt.Sprint(nums[len(nums)-1]) + ".seg"
+	f, _ := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0644)
+	_, _ = f.Write(encodeSegmentRecord(segmentOpPut, 99, 1000, []byte("partial"))[:20])
+	_ = f.Close()
+
+	db, _ = OpenDB("testdb-seg")
+	c, err = OpenCollection[ExamplePersonStruct](db, "testcollection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+
+	x, _ = c.Select(func(p ExamplePersonStruct) bool { return true })
+	if len(x) != 14 || x[0] != (ExamplePersonStruct{Name: "ExamplePersonStruct 1", Age: 10}) {
+		t.Fatalf("unexpected records after reopening: %v", x)
+	}
+	if _, err := c.GetByID(2); err != ErrNotFound {
+		t.Fatalf("expected deleted record to stay deleted, got %v", err)
+	}
+
+	id, _ := c.InsertWithID(ExamplePersonStruct{Name: "ExamplePersonStruct 21", Age: 21})
+	if id != 21 {
+		t.Fatalf("expected ID 21, got %d", id)
+	}
+}
+
+func segmentsSize(t *testing.T, dir string) int64 {
+	nums, _ := segmentNumbers(dir)
+	var total int64
+	for _, num := range nums {
+		info, err := os.Stat(dir + "/s" + fmt.Sprint(num) + ".seg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += info.Size()
+	}
+	return total
+}
+
+func TestCompaction(t *testing.T) {
+	db, _ := OpenDB("testdb-compact", WithStorage(SegmentStorage), WithSegmentSize(1024), WithCompactionThreshold(0))
+	defer func() { _ = os.RemoveAll("testdb-compact") }()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	i2, _ := OpenIndex[ExamplePersonStruct, int](&c, "age", func(p ExamplePersonStruct) int {
+		return p.Age
+	})
+
+	for i := 1; i <= 50; i++ {
+		_ = c.Insert(ExamplePersonStruct{Name: fmt.Sprintf("ExamplePersonStruct %d", i), Age: i})
+	}
+	_ = c.Modify(func(p ExamplePersonStruct) bool { return true }, func(p ExamplePersonStruct) ExamplePersonStruct { p.Age *= 2; return p })
+	_ = c.Delete(func(p ExamplePersonStruct) bool { return p.Age > 50 })
+	before := segmentsSize(t, "testdb-compact/testcollection")
+
+	reclaimed, err := c.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := segmentsSize(t, "testdb-compact/testcollection")
+	if reclaimed <= 0 || before-after != reclaimed {
+		t.Fatalf("reclaimed %d, but segments went from %d to %d bytes", reclaimed, before, after)
+	}
+
+	x, _ := i2.Get(20)
+	if !verifyItemsEqual(x, []ExamplePersonStruct{{Name: "ExamplePersonStruct 10", Age: 20}}) {
+		t.Fatal("records not readable after compaction")
+	}
+	_ = c.Insert(ExamplePersonStruct{Name: "ExamplePersonStruct 51", Age: 51})
+
+	_ = db.Close()
+	db, _ = OpenDB("testdb-compact")
+	c, _ = OpenCollection[ExamplePersonStruct](db, "testcollection")
+	defer func() { _ = db.Close() }()
+
+	x, _ = c.Select(func(p ExamplePersonStruct) bool { return true })
+	if len(x) != 26 || x[len(x)-1] != (ExamplePersonStruct{Name: "ExamplePersonStruct 51", Age: 51}) {
+		t.Fatalf("unexpected records after reopening: %v", x)
+	}
+	if id, _ := c.InsertWithID(ExamplePersonStruct{Name: "ExamplePersonStruct 52", Age: 52}); id != 52 {
+		t.Fatalf("expected ID 52, got %d", id)
+	}
+}
+
+func TestAutomaticCompaction(t *testing.T) {
+	db, _ := OpenDB("testdb-autocompact", WithStorage(SegmentStorage), WithSegmentSize(1024), WithCompactionThreshold(0.5))
+	defer func() { _ = os.RemoveAll("testdb-autocompact") }()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+
+	_ = c.Insert(ExamplePersonStruct{Name: "ExamplePersonStruct 1", Age: 0})
+	for i := 1; i <= 500; i++ {
+		_ = c.Modify(func(p ExamplePersonStruct) bool { return true }, func(p ExamplePersonStruct) ExamplePersonStruct { p.Age = i; return p })
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 500 versions of the record take up well over 10k, without compaction
+	if size := segmentsSize(t, "testdb-autocompact/testcollection"); size > 4096 {
+		t.Fatalf("expected dead versions to be compacted away, segments take %d bytes", size)
+	}
+
+	db, _ = OpenDB("testdb-autocompact")
+	c, _ = OpenCollection[ExamplePersonStruct](db, "testcollection")
+	defer func() { _ = db.Close() }()
+	x, _ := c.Select(func(p ExamplePersonStruct) bool { return true })
+	if !verifyItemsEqual(x, []ExamplePersonStruct{{Name: "ExamplePersonStruct 1", Age: 500}}) {
+		t.Fatalf("unexpected records after compaction: %v", x)
+	}
+}
@@ -0,0 +1,183 @@
+package gobble
+
+import (
+	"cmp"
+	"container/heap"
+	"fmt"
+	"slices"
+)
+
+// SelectOption sorts or limits the records returned by Select and Find.
+type SelectOption func(*selectOptions)
+
+type selectOptions struct {
+	compare    any // func(a, b T) int for the T of the collection
+	descending bool
+	limit      int
+	offset     int
+}
+
+// SortBy sorts the records with compare, which returns a negative number when a comes before b, a positive number
+// when it comes after, and 0 to keep them in ID order (like the compare functions of the slices package).
+func SortBy[T any](compare func(a, b T) int) SelectOption {
+	return func(o *selectOptions) {
+		o.compare = compare
+	}
+}
+
+// SortByKey sorts the records by the key returned for each of them, ascending.
+func SortByKey[T any, K cmp.Ordered](key func(T) K) SelectOption {
+	return SortBy(func(a, b T) int {
+		return cmp.Compare(key(a), key(b))
+	})
+}
+
+// Descending reverses the order of SortBy and SortByKey, records that compare equal stay in ID order.
+func Descending() SelectOption {
+	return func(o *selectOptions) {
+		o.descending = true
+	}
+}
+
+// Limit returns at most n records.
+func Limit(n int) SelectOption {
+	return func(o *selectOptions) {
+		o.limit = n
+	}
+}
+
+// Offset skips the first n records.
+func Offset(n int) SelectOption {
+	return func(o *selectOptions) {
+		o.offset = n
+	}
+}
+
+// selection collects the records matched by a query as the select options say. Records must be added in ID order.
+//
+// Without sorting, it only needs the first offset+limit records. With sorting and a limit, it keeps the best
+// offset+limit records so far in a heap whose root is the worst of them, so the result set is never materialized.
+type selection[T any] struct {
+	compare func(a, b T) int
+	limit   int
+	offset  int
+
+	skipped int // records left out for the offset, without sorting
+	results []T
+	added   int // records added, with sorting
+	top     selectionHeap[T]
+}
+
+type selectionItem[T any] struct {
+	data T
+	seq  int // order the record was added in, to keep records that compare equal in ID order
+}
+
+func newSelection[T any](opts []SelectOption) (*selection[T], error) {
+	var o selectOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.limit < 0 || o.offset < 0 {
+		return nil, fmt.Errorf("negative limit or offset")
+	}
+
+	s := &selection[T]{limit: o.limit, offset: o.offset}
+	if o.compare != nil {
+		compare, ok := o.compare.(func(a, b T) int)
+		if !ok {
+			return nil, fmt.Errorf("sort function is for a different type than the collection")
+		}
+		s.compare = compare
+		if o.descending {
+			s.compare = func(a, b T) int { return compare(b, a) }
+		}
+	}
+	s.top.compare = s.compare
+
+	return s, nil
+}
+
+// add adds a matched record, and returns false once no more are needed
+func (t *selection[T]) add(data T) bool {
+	if t.compare == nil {
+		if t.skipped < t.offset {
+			t.skipped++
+			return true
+		}
+
+		t.results = append(t.results, data)
+		return t.limit == 0 || len(t.results) < t.limit
+	}
+
+	item := selectionItem[T]{data: data, seq: t.added}
+	t.added++
+	if t.limit == 0 {
+		t.top.items = append(t.top.items, item)
+		return true
+	}
+
+	heap.Push(&t.top, item)
+	if len(t.top.items) > t.offset+t.limit {
+		heap.Pop(&t.top)
+	}
+	return true
+}
+
+// get returns the selected records
+func (t *selection[T]) get() []T {
+	if t.compare == nil {
+		return t.results
+	}
+
+	items := t.top.items
+	slices.SortFunc(items, t.top.order)
+	if t.offset >= len(items) {
+		return nil
+	}
+	items = items[t.offset:]
+	if t.limit > 0 && len(items) > t.limit {
+		items = items[:t.limit]
+	}
+
+	results := make([]T, len(items))
+	for i, item := range items {
+		results[i] = item.data
+	}
+	return results
+}
+
+// selectionHeap is a heap.Interface with the record that comes last at the root
+type selectionHeap[T any] struct {
+	compare func(a, b T) int
+	items   []selectionItem[T]
+}
+
+func (t *selectionHeap[T]) order(a, b selectionItem[T]) int {
+	if c := t.compare(a.data, b.data); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.seq, b.seq)
+}
+
+func (t *selectionHeap[T]) Len() int {
+	return len(t.items)
+}
+
+func (t *selectionHeap[T]) Less(i, j int) bool {
+	return t.order(t.items[i], t.items[j]) > 0
+}
+
+func (t *selectionHeap[T]) Swap(i, j int) {
+	t.items[i], t.items[j] = t.items[j], t.items[i]
+}
+
+func (t *selectionHeap[T]) Push(x any) {
+	t.items = append(t.items, x.(selectionItem[T]))
+}
+
+func (t *selectionHeap[T]) Pop() any {
+	last := t.items[len(t.items)-1]
+	t.items = t.items[:len(t.items)-1]
+	return last
+}
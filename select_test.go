@@ -0,0 +1,62 @@
+package gobble
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestSelectOptions(t *testing.T) {
+	db, _ := OpenDB("testdb-select")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-select")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	for i := 0; i < 50; i++ {
+		_ = c.Insert(ExamplePersonStruct{Name: fmt.Sprintf("Person %d", i), Age: (i * 7) % 10})
+	}
+	all := func(p ExamplePersonStruct) bool { return true }
+	names := func(ps []ExamplePersonStruct) []string {
+		var s []string
+		for _, p := range ps {
+			s = append(s, p.Name)
+		}
+		return s
+	}
+
+	// ID order by default
+	x, _ := c.Select(all, Offset(10), Limit(3))
+	if !slices.Equal(names(x), []string{"Person 10", "Person 11", "Person 12"}) {
+		t.Fatalf("unexpected page %v", names(x))
+	}
+
+	byAge := SortByKey(func(p ExamplePersonStruct) int { return p.Age })
+	sorted, _ := c.Select(all, byAge)
+	if len(sorted) != 50 || !slices.IsSortedFunc(sorted, func(a, b ExamplePersonStruct) int { return cmp.Compare(a.Age, b.Age) }) {
+		t.Fatalf("not sorted by age: %v", sorted)
+	}
+	if sorted[0].Name != "Person 0" || sorted[1].Name != "Person 10" {
+		t.Fatalf("equal ages not in ID order: %v", names(sorted[:2]))
+	}
+
+	// The top-K path gives the same records as sorting everything
+	for _, offset := range []int{0, 3, 48, 60} {
+		x, _ := c.Select(all, byAge, Offset(offset), Limit(7))
+		expected := sorted[min(offset, 50):min(offset+7, 50)]
+		if !slices.Equal(x, expected) && (len(x) != 0 || len(expected) != 0) {
+			t.Fatalf("unexpected top-K at offset %d: %v, expected %v", offset, x, expected)
+		}
+	}
+
+	x, _ = c.Select(func(p ExamplePersonStruct) bool { return p.Age > 7 }, byAge, Descending(), Limit(2))
+	if !slices.Equal(names(x), []string{"Person 7", "Person 17"}) {
+		t.Fatalf("unexpected descending records %v", names(x))
+	}
+
+	if _, err := c.Select(all, SortBy(func(a, b string) int { return 0 })); err == nil {
+		t.Fatal("sort function for another type accepted")
+	}
+}
@@ -0,0 +1,125 @@
+package gobble
+
+import (
+	"cmp"
+	"math/bits"
+	"math/rand/v2"
+)
+
+// skipList maps keys to the file IDs of the records with that key, in key order.
+// Each node is linked on level 0 and, with halving probability, on each level above, so finding a key takes about
+// log2(n) steps. Level 0 is also linked backwards, for iterating in descending order.
+type skipList[K cmp.Ordered] struct {
+	head  *skipNode[K] // sentinel, holds no key
+	tail  *skipNode[K]
+	level int
+	len   int
+}
+
+type skipNode[K cmp.Ordered] struct {
+	key     K
+	fileIDs []string
+	next    []*skipNode[K]
+	prev    *skipNode[K]
+}
+
+const skipListMaxLevel = 32
+
+func newSkipList[K cmp.Ordered]() *skipList[K] {
+	return &skipList[K]{head: &skipNode[K]{next: make([]*skipNode[K], skipListMaxLevel)}, level: 1}
+}
+
+// path returns the last node before key on every level
+func (t *skipList[K]) path(key K) []*skipNode[K] {
+	update := make([]*skipNode[K], skipListMaxLevel)
+	n := t.head
+	for l := t.level - 1; l >= 0; l-- {
+		for n.next[l] != nil && cmp.Less(n.next[l].key, key) {
+			n = n.next[l]
+		}
+		update[l] = n
+	}
+	return update
+}
+
+// seek returns the first node with a key >= key, or nil
+func (t *skipList[K]) seek(key K) *skipNode[K] {
+	n := t.head
+	for l := t.level - 1; l >= 0; l-- {
+		for n.next[l] != nil && cmp.Less(n.next[l].key, key) {
+			n = n.next[l]
+		}
+	}
+	return n.next[0]
+}
+
+func (t *skipList[K]) get(key K) *skipNode[K] {
+	n := t.seek(key)
+	if n == nil || cmp.Compare(n.key, key) != 0 {
+		return nil
+	}
+	return n
+}
+
+func (t *skipList[K]) first() *skipNode[K] {
+	return t.head.next[0]
+}
+
+func (t *skipList[K]) add(key K, fileID string) {
+	update := t.path(key)
+	if n := update[0].next[0]; n != nil && cmp.Compare(n.key, key) == 0 {
+		n.fileIDs = append(n.fileIDs, fileID)
+		return
+	}
+
+	level := min(bits.TrailingZeros64(rand.Uint64())+1, skipListMaxLevel)
+	for ; t.level < level; t.level++ {
+		update[t.level] = t.head
+	}
+
+	n := &skipNode[K]{key: key, fileIDs: []string{fileID}, next: make([]*skipNode[K], level)}
+	for l := 0; l < level; l++ {
+		n.next[l] = update[l].next[l]
+		update[l].next[l] = n
+	}
+	if update[0] != t.head {
+		n.prev = update[0]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n
+	} else {
+		t.tail = n
+	}
+	t.len++
+}
+
+func (t *skipList[K]) remove(key K, fileID string) {
+	update := t.path(key)
+	n := update[0].next[0]
+	if n == nil || cmp.Compare(n.key, key) != 0 {
+		return
+	}
+
+	for i, id := range n.fileIDs {
+		if id == fileID {
+			n.fileIDs = append(n.fileIDs[:i], n.fileIDs[i+1:]...)
+			break
+		}
+	}
+	if len(n.fileIDs) > 0 {
+		return
+	}
+
+	for l := 0; l < len(n.next); l++ {
+		update[l].next[l] = n.next[l]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n.prev
+	} else {
+		t.tail = n.prev
+	}
+	for t.level > 1 && t.head.next[t.level-1] == nil {
+		t.level--
+	}
+	t.len--
+}
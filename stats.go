@@ -0,0 +1,160 @@
+package gobble
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Index statistics
+//
+// Every index with keys can describe its keys and how many records each of them has. These only look at the index,
+// no record is read.
+
+// KeyCount is a key of an index and the number of records with it, see TopN.
+type KeyCount[K any] struct {
+	Key   K
+	Count int
+}
+
+// statGroups calls fn with every key of index and its file IDs, with the collection locked for reading
+func statGroups[T any, K comparable](index groupIndex[T, K], fn func(key K, fileIDs []string)) error {
+	c := index.collection()
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+
+	return index.groups(func(key K, fileIDs []string) bool {
+		fn(key, fileIDs)
+		return true
+	})
+}
+
+func statKeys[T any, K comparable](index groupIndex[T, K]) ([]K, error) {
+	keys := []K{}
+	err := statGroups(index, func(key K, _ []string) { keys = append(keys, key) })
+	return keys, err
+}
+
+func statCardinality[T any, K comparable](index groupIndex[T, K]) (int, error) {
+	n := 0
+	err := statGroups(index, func(K, []string) { n++ })
+	return n, err
+}
+
+func statHistogram[T any, K comparable](index groupIndex[T, K]) (map[K]int, error) {
+	histogram := map[K]int{}
+	err := statGroups(index, func(key K, fileIDs []string) { histogram[key] = len(fileIDs) })
+	return histogram, err
+}
+
+// statTopN returns the n keys with the most records, ties in the order the index goes through its keys
+func statTopN[T any, K comparable](index groupIndex[T, K], n int) ([]KeyCount[K], error) {
+	var counts []KeyCount[K]
+	err := statGroups(index, func(key K, fileIDs []string) {
+		counts = append(counts, KeyCount[K]{Key: key, Count: len(fileIDs)})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(counts, func(a, b KeyCount[K]) int { return cmp.Compare(b.Count, a.Count) })
+	if n >= 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	if counts == nil {
+		counts = []KeyCount[K]{}
+	}
+
+	return counts, nil
+}
+
+// Keys returns the keys that have records, in no particular order
+func (t *Index[T, D]) Keys() ([]D, error) {
+	return statKeys[T, D](t)
+}
+
+// Cardinality returns the number of different keys that have records
+func (t *Index[T, D]) Cardinality() (int, error) {
+	return statCardinality[T, D](t)
+}
+
+// Histogram returns the number of records of every key
+func (t *Index[T, D]) Histogram() (map[D]int, error) {
+	return statHistogram[T, D](t)
+}
+
+// TopN returns the n keys with the most records, most first, ties in no particular order
+func (t *Index[T, D]) TopN(n int) ([]KeyCount[D], error) {
+	return statTopN[T, D](t, n)
+}
+
+// Keys returns the keys that have records, in key order
+func (t *OrderedIndex[T, K]) Keys() ([]K, error) {
+	return statKeys[T, K](t)
+}
+
+// Cardinality returns the number of different keys that have records
+func (t *OrderedIndex[T, K]) Cardinality() (int, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return 0, err
+	}
+	return t.keys.len, nil
+}
+
+// Histogram returns the number of records of every key
+func (t *OrderedIndex[T, K]) Histogram() (map[K]int, error) {
+	return statHistogram[T, K](t)
+}
+
+// TopN returns the n keys with the most records, most first, ties in key order
+func (t *OrderedIndex[T, K]) TopN(n int) ([]KeyCount[K], error) {
+	return statTopN[T, K](t, n)
+}
+
+// Keys returns the keys that have records, in no particular order
+func (t *MultiIndex[T, K]) Keys() ([]K, error) {
+	return statKeys[T, K](t)
+}
+
+// Cardinality returns the number of different keys that have records
+func (t *MultiIndex[T, K]) Cardinality() (int, error) {
+	return statCardinality[T, K](t)
+}
+
+// Histogram returns the number of records listed under every key
+func (t *MultiIndex[T, K]) Histogram() (map[K]int, error) {
+	return statHistogram[T, K](t)
+}
+
+// TopN returns the n keys with the most records, most first, ties in no particular order
+func (t *MultiIndex[T, K]) TopN(n int) ([]KeyCount[K], error) {
+	return statTopN[T, K](t, n)
+}
+
+// Keys returns the keys that have records, in key order
+func (t *PrefixIndex[T]) Keys() ([]string, error) {
+	return statKeys[T, string](t)
+}
+
+// Cardinality returns the number of different keys that have records
+func (t *PrefixIndex[T]) Cardinality() (int, error) {
+	t.Collection.state.mu.RLock()
+	defer t.Collection.state.mu.RUnlock()
+
+	if err := t.check(); err != nil {
+		return 0, err
+	}
+	return t.keys.len, nil
+}
+
+// Histogram returns the number of records of every key
+func (t *PrefixIndex[T]) Histogram() (map[string]int, error) {
+	return statHistogram[T, string](t)
+}
+
+// TopN returns the n keys with the most records, most first, ties in key order
+func (t *PrefixIndex[T]) TopN(n int) ([]KeyCount[string], error) {
+	return statTopN[T, string](t, n)
+}
@@ -0,0 +1,50 @@
+package gobble
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestIndexStats(t *testing.T) {
+	db, _ := OpenDB("testdb-stats")
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-stats")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	for i := 0; i < 20; i++ {
+		_ = c.Insert(ExamplePersonStruct{Name: fmt.Sprintf("Person %d", i), Age: i % 7 % 4})
+	}
+
+	// Statistics come from the index alone, so the extractor is never called for them
+	reads := 0
+	byAge, _ := OpenIndex[ExamplePersonStruct, int](&c, "age", func(p ExamplePersonStruct) int { reads++; return p.Age })
+	ordered, _ := OpenOrderedIndex[ExamplePersonStruct, int](&c, "ordered-age", func(p ExamplePersonStruct) int { return p.Age })
+	reads = 0
+
+	if n, _ := byAge.Cardinality(); n != 4 {
+		t.Fatalf("expected 4 keys, got %d", n)
+	}
+	if keys, _ := ordered.Keys(); !slices.Equal(keys, []int{0, 1, 2, 3}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	if h, _ := byAge.Histogram(); h[0] != 6 || h[1] != 6 || h[2] != 5 || h[3] != 3 {
+		t.Fatalf("unexpected histogram %v", h)
+	}
+	if top, _ := ordered.TopN(3); !slices.Equal(top, []KeyCount[int]{{0, 6}, {1, 6}, {2, 5}}) {
+		t.Fatalf("unexpected top keys %v", top)
+	}
+	if reads != 0 {
+		t.Fatal("records read for statistics")
+	}
+
+	_ = c.Delete(func(p ExamplePersonStruct) bool { return p.Age == 3 })
+	if n, _ := byAge.Cardinality(); n != 3 {
+		t.Fatalf("expected 3 keys after deleting, got %d", n)
+	}
+	if n, _ := ordered.Cardinality(); n != 3 {
+		t.Fatalf("expected 3 keys after deleting, got %d", n)
+	}
+}
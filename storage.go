@@ -0,0 +1,272 @@
+package gobble
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage selects how a collection lays out its records on disk.
+type Storage int
+
+const (
+	// FileStorage keeps every record in its own gob file. Simple to inspect, but every scan opens one file per record.
+	FileStorage Storage = iota
+	// SegmentStorage appends records to a few large segment files and keeps an in-memory table of where each one is,
+	// which makes scans and writes much cheaper for big collections.
+	SegmentStorage
+)
+
+// Option configures OpenDB or OpenCollection.
+type Option func(*options)
+
+type options struct {
+	lockMode            LockMode
+	syncMode            SyncMode
+	syncInterval        time.Duration
+	storage             Storage
+	segmentSize         int64
+	compactionThreshold float64
+}
+
+func defaultOptions() options {
+	return options{
+		lockMode:            LockExclusive,
+		storage:             FileStorage,
+		segmentSize:         16 << 20,
+		compactionThreshold: 0.5,
+	}
+}
+
+// WithStorage selects the storage engine used for newly created collections.
+func WithStorage(storage Storage) Option {
+	return func(o *options) {
+		o.storage = storage
+	}
+}
+
+// WithSegmentSize sets the size in bytes after which SegmentStorage starts a new segment file.
+func WithSegmentSize(size int64) Option {
+	return func(o *options) {
+		o.segmentSize = size
+	}
+}
+
+// WithCompactionThreshold sets the fraction of SegmentStorage space taken by old versions of records and tombstones
+// at which a compaction is started in the background (once there is at least a segment's worth of it).
+// 0 disables automatic compaction, Collection.Compact can still be called.
+func WithCompactionThreshold(ratio float64) Option {
+	return func(o *options) {
+		o.compactionThreshold = ratio
+	}
+}
+
+// storage is where a collection keeps its records, as gob encoded bytes keyed by record ID.
+// Collection[T] does the encoding and decoding, so storage engines don't need to know T.
+// Writes don't need to be durable until sync is called, the write-ahead log covers them until then.
+type storage interface {
+	nextID() (int, error)
+	get(id int) ([]byte, error)
+	put(id int, data []byte) error
+	remove(id int) error
+	ids() ([]int, error) // in ascending order
+	count() (int, error)
+	version() uint64 // changes with every put and remove, and is persisted by sync
+	sync() error
+	close() error
+}
+
+// compacter is implemented by storage engines that leave dead space behind when records are overwritten or removed
+type compacter interface {
+	compact() (int64, error)
+}
+
+func openStorage(dir string, o options) (storage, error) {
+	meta, err := readMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	switch meta.Storage {
+	case FileStorage:
+		return &fileStore{dir: dir, lastID: meta.LastID, writes: meta.Version}, nil
+	case SegmentStorage:
+		return openSegmentStore(dir, o)
+	default:
+		return nil, fmt.Errorf("unknown storage engine %d", meta.Storage)
+	}
+}
+
+// The metadata doesn't depend on the collection's type, so storage engines use it with T = any
+func readMetadata(dir string) (CollectionMetadata[any], error) {
+	b, err := os.ReadFile(dir + "/meta.gob")
+	if err != nil {
+		return CollectionMetadata[any]{}, err
+	}
+
+	var meta CollectionMetadata[any]
+	dec := gob.NewDecoder(bytes.NewReader(b))
+	err = dec.Decode(&meta)
+	if err != nil {
+		return CollectionMetadata[any]{}, err
+	}
+
+	return meta, nil
+}
+
+func writeMetadata(dir string, meta CollectionMetadata[any]) error {
+	return writeGobAtomic(dir+"/meta.gob", meta)
+}
+
+// fileStore is the original layout: one "d<ID>.gob" file per record and the last used ID in "meta.gob"
+type fileStore struct {
+	dir string
+
+	mu     sync.Mutex
+	lastID int
+	writes uint64
+	dirty  bool // the directory, the last used ID or the write count changed since the last sync
+}
+
+func (t *fileStore) path(id int) string {
+	return t.dir + "/d" + strconv.Itoa(id) + ".gob"
+}
+
+func (t *fileStore) nextID() (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastID++
+	t.dirty = true
+	return t.lastID, nil
+}
+
+func (t *fileStore) get(id int) ([]byte, error) {
+	b, err := os.ReadFile(t.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	return b, err
+}
+
+// put writes the record file synced, only its rename is left to sync. A record file that was renamed into place is
+// always complete, even if the machine crashed before the log entry for it was synced.
+func (t *fileStore) put(id int, data []byte) error {
+	if err := writeFileAtomic(t.path(id), data, false); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.dirty = true
+	t.writes++
+	if id > t.lastID {
+		t.lastID = id
+	}
+	return nil
+}
+
+func (t *fileStore) remove(id int) error {
+	err := os.Remove(t.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.dirty = true
+	t.writes++
+	return nil
+}
+
+func (t *fileStore) version() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.writes
+}
+
+// sync makes the renames and removals of record files since the last sync and the last used ID durable
+func (t *fileStore) sync() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.dirty {
+		return nil
+	}
+
+	meta, err := readMetadata(t.dir)
+	if err != nil {
+		return err
+	}
+	if meta.LastID < t.lastID {
+		meta.LastID = t.lastID
+	}
+	meta.Version = t.writes
+
+	// Also syncs the directory, for the renames and removals of record files
+	if err := writeMetadata(t.dir, meta); err != nil {
+		return err
+	}
+	t.dirty = false
+
+	return nil
+}
+
+func (t *fileStore) ids() ([]int, error) {
+	dir, err := os.Open(t.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer func(dir *os.File) {
+		_ = dir.Close()
+	}(dir)
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, name := range names {
+		if !strings.HasPrefix(name, "d") || !strings.HasSuffix(name, ".gob") {
+			continue
+		}
+
+		id, err := strconv.Atoi(name[1 : len(name)-4])
+		if err != nil {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (t *fileStore) count() (int, error) {
+	ids, err := t.ids()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+func (t *fileStore) close() error {
+	return nil
+}
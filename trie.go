@@ -0,0 +1,126 @@
+package gobble
+
+import (
+	"sort"
+)
+
+// trie maps string keys to the file IDs of the records with that key. Every node stands for the key made of the bytes
+// on the path to it, and its children are sorted by byte, so walking it depth first visits the keys in order.
+type trie struct {
+	root trieNode
+	len  int // number of keys
+}
+
+type trieNode struct {
+	b        byte
+	children []*trieNode
+	fileIDs  []string
+}
+
+func newTrie() *trie {
+	return &trie{}
+}
+
+// child returns the child of n for b, and where it is or would be inserted
+func (n *trieNode) child(b byte) (*trieNode, int) {
+	i := sort.Search(len(n.children), func(i int) bool { return n.children[i].b >= b })
+	if i < len(n.children) && n.children[i].b == b {
+		return n.children[i], i
+	}
+	return nil, i
+}
+
+// find returns the node for key, or nil if no key starts with it
+func (t *trie) find(key string) *trieNode {
+	n := &t.root
+	for i := 0; i < len(key) && n != nil; i++ {
+		n, _ = n.child(key[i])
+	}
+	return n
+}
+
+func (t *trie) get(key string) []string {
+	if n := t.find(key); n != nil {
+		return n.fileIDs
+	}
+	return nil
+}
+
+func (t *trie) add(key string, fileID string) {
+	n := &t.root
+	for i := 0; i < len(key); i++ {
+		c, at := n.child(key[i])
+		if c == nil {
+			c = &trieNode{b: key[i]}
+			n.children = append(n.children, nil)
+			copy(n.children[at+1:], n.children[at:])
+			n.children[at] = c
+		}
+		n = c
+	}
+
+	if len(n.fileIDs) == 0 {
+		t.len++
+	}
+	n.fileIDs = append(n.fileIDs, fileID)
+}
+
+func (t *trie) remove(key string, fileID string) {
+	// The nodes on the way to key, to prune the ones left empty
+	path := make([]*trieNode, 0, len(key)+1)
+	n := &t.root
+	path = append(path, n)
+	for i := 0; i < len(key); i++ {
+		if n, _ = n.child(key[i]); n == nil {
+			return
+		}
+		path = append(path, n)
+	}
+
+	for i, id := range n.fileIDs {
+		if id == fileID {
+			n.fileIDs = append(n.fileIDs[:i], n.fileIDs[i+1:]...)
+			if len(n.fileIDs) == 0 {
+				t.len--
+			}
+			break
+		}
+	}
+
+	for i := len(path) - 1; i > 0; i-- {
+		n := path[i]
+		if len(n.fileIDs) > 0 || len(n.children) > 0 {
+			return
+		}
+
+		parent := path[i-1]
+		_, at := parent.child(n.b)
+		parent.children = append(parent.children[:at], parent.children[at+1:]...)
+	}
+}
+
+// walk calls fn with every key starting with prefix and its file IDs in key order, until fn returns false
+func (t *trie) walk(prefix string, fn func(key string, fileIDs []string) bool) {
+	n := t.find(prefix)
+	if n == nil {
+		return
+	}
+
+	key := []byte(prefix)
+	var visit func(n *trieNode) bool
+	visit = func(n *trieNode) bool {
+		if len(n.fileIDs) > 0 && !fn(string(key), n.fileIDs) {
+			return false
+		}
+		for _, c := range n.children {
+			key = append(key, c.b)
+			ok := visit(c)
+			key = key[:len(key)-1]
+			if !ok {
+				return false
+			}
+		}
+		return true
+	}
+	visit(n)
+}
@@ -0,0 +1,448 @@
+package gobble
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Transactions
+//
+// Writes done through a Tx are kept in memory until the function passed to DB.Update returns. To commit, the final
+// state of every record the transaction touched is appended to the write-ahead log as one batch and synced, then applied
+// to the collections. Appending the batch is the commit point: if the process stops before it, nothing was applied, and
+// if it stops after it, OpenDB replays the whole batch.
+
+// Tx is a set of writes to collections of one DB that are applied together or not at all, see DB.Update.
+type Tx struct {
+	db      *DB
+	order   []string // names of the collections used, in the order they were first used
+	targets map[string]txTarget
+	done    bool
+}
+
+// TxCollection is a collection used through a transaction, see Collection.Tx.
+// Reads through it see the transaction's own writes.
+type TxCollection[T any] struct {
+	tx      *Tx
+	c       *Collection[T]
+	pending map[int]txRecord
+	handled map[int]bool // deleted records whose references were applied
+	err     error
+}
+
+type txRecord struct {
+	data    []byte
+	deleted bool
+}
+
+// txTarget is what Tx needs from a TxCollection, without knowing its type
+type txTarget interface {
+	deleteReferring() (bool, error)
+	checkConstraints() error
+	entries(name string) []walEntry
+	apply(entry walEntry) error
+	unlock()
+}
+
+// ErrTxRunning is returned by the deletes of a collection other collections refer to while a transaction is running on
+// the DB. Such deletes run as transactions themselves, and called inside DB.Update they would wait forever for the
+// transaction they are part of, so they fail instead. Inside DB.Update, delete through the Tx, elsewhere retry.
+var ErrTxRunning = errors.New("a transaction is already running on the database")
+
+// Update runs fn in a transaction. If fn returns nil, all writes done through tx are committed together, including
+// their index updates. If fn returns an error or panics, or the writes break a unique index, none of them are.
+//
+// Transactions run one at a time. The collections used through tx are locked from their first use until the end of the
+// transaction, so fn must not use them other than through tx, or call Update itself. Deleting from a collection other
+// collections refer to runs a transaction, see OpenReference, so doing that other than through tx returns ErrTxRunning.
+//
+// When tx commits, the references to the records it deletes are applied, and their cascaded deletes and updates are
+// committed with the rest, or with Restrict, refuse the commit with *ErrReferenced.
+func (t *DB) Update(fn func(tx *Tx) error) error {
+	return t.update(fn, true)
+}
+
+// update is Update, returning ErrTxRunning instead of waiting for a running transaction unless wait is set
+func (t *DB) update(fn func(tx *Tx) error, wait bool) error {
+	if t.state == nil {
+		return fmt.Errorf("db was not opened with OpenDB")
+	}
+	if t.state.opts.lockMode == LockShared {
+		return ErrReadOnly
+	}
+
+	if wait {
+		t.state.txMu.Lock()
+	} else if !t.state.txMu.TryLock() {
+		return ErrTxRunning
+	}
+	defer t.state.txMu.Unlock()
+
+	tx := &Tx{db: t, targets: map[string]txTarget{}}
+	defer func() {
+		tx.done = true
+		for _, name := range tx.order {
+			tx.targets[name].unlock()
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.commit()
+}
+
+func (t *Tx) commit() error {
+	if err := t.deleteReferring(); err != nil {
+		return err
+	}
+
+	var entries []walEntry
+	for _, name := range t.order {
+		if err := t.targets[name].checkConstraints(); err != nil {
+			return err
+		}
+		entries = append(entries, t.targets[name].entries(name)...)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	return t.db.state.wal.log(entries, true, func() error {
+		for _, entry := range entries {
+			if err := t.targets[entry.Collection].apply(entry); err != nil {
+				return fmt.Errorf("transaction committed but not fully applied, it will be completed by the next OpenDB: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// deleteReferring applies the references to the records the transaction deletes, until the records it deletes through
+// them have had theirs applied too
+func (t *Tx) deleteReferring() error {
+	for more := true; more; {
+		more = false
+		// Cascades may use more collections, which are added to t.order
+		for i := 0; i < len(t.order); i++ {
+			found, err := t.targets[t.order[i]].deleteReferring()
+			if err != nil {
+				return err
+			}
+			more = more || found
+		}
+	}
+
+	return nil
+}
+
+// Tx returns the collection for use within tx. The first use of a collection in a transaction locks it until the
+// transaction ends.
+func (t *Collection[T]) Tx(tx *Tx) *TxCollection[T] {
+	if tx.done {
+		return &TxCollection[T]{tx: tx, c: t, err: fmt.Errorf("transaction has already ended")}
+	}
+	if t.DB.state != tx.db.state {
+		return &TxCollection[T]{tx: tx, c: t, err: fmt.Errorf("collection is not from the transaction's db")}
+	}
+
+	if target, ok := tx.targets[t.Name]; ok {
+		if tc, ok := target.(*TxCollection[T]); ok {
+			return tc
+		}
+		return &TxCollection[T]{tx: tx, c: t, err: fmt.Errorf("collection already used with a different type in this transaction")}
+	}
+
+	t.state.mu.Lock()
+	tc := &TxCollection[T]{tx: tx, c: t, pending: map[int]txRecord{}, handled: map[int]bool{}}
+	tx.targets[t.Name] = tc
+	tx.order = append(tx.order, t.Name)
+
+	if t.state.readOnly {
+		tc.err = ErrReadOnly
+	}
+
+	return tc
+}
+
+func (t *TxCollection[T]) check() error {
+	if t.err != nil {
+		return t.err
+	}
+	if t.tx.done {
+		return fmt.Errorf("transaction has already ended")
+	}
+	return nil
+}
+
+// read returns the record as the transaction sees it
+func (t *TxCollection[T]) read(id int) (T, error) {
+	rec, ok := t.pending[id]
+	if !ok {
+		return t.c.read(id)
+	}
+
+	var data T
+	if rec.deleted {
+		return data, ErrNotFound
+	}
+
+	dec := gob.NewDecoder(bytes.NewReader(rec.data))
+	err := dec.Decode(&data)
+	return data, err
+}
+
+func (t *TxCollection[T]) write(id int, data T) error {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(data); err != nil {
+		return err
+	}
+
+	t.pending[id] = txRecord{data: buf.Bytes()}
+	return nil
+}
+
+// ids returns the IDs of the records as the transaction sees them, in ascending order
+func (t *TxCollection[T]) ids() ([]int, error) {
+	stored, err := t.c.state.store.ids()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	isStored := make(map[int]bool, len(stored))
+	for _, id := range stored {
+		isStored[id] = true
+		if rec, ok := t.pending[id]; !ok || !rec.deleted {
+			ids = append(ids, id)
+		}
+	}
+	for id, rec := range t.pending {
+		if !isStored[id] && !rec.deleted {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (t *TxCollection[T]) Insert(data T) error {
+	_, err := t.InsertWithID(data)
+	return err
+}
+
+// InsertWithID inserts data when the transaction commits. The ID is allocated right away, if the transaction doesn't
+// commit it is never used.
+func (t *TxCollection[T]) InsertWithID(data T) (int, error) {
+	if err := t.check(); err != nil {
+		return 0, err
+	}
+
+	id, err := t.c.state.store.nextID()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, t.write(id, data)
+}
+
+func (t *TxCollection[T]) GetByID(id int) (T, error) {
+	if err := t.check(); err != nil {
+		var data T
+		return data, err
+	}
+
+	return t.read(id)
+}
+
+func (t *TxCollection[T]) Select(query Query[T], opts ...SelectOption) ([]T, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
+	s, err := newSelection[T](opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := t.ids()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		data, err := t.read(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if query(data) && !s.add(data) {
+			break
+		}
+	}
+
+	return s.get(), nil
+}
+
+func (t *TxCollection[T]) Modify(query Query[T], updater Updater[T]) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	ids, err := t.ids()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		data, err := t.read(id)
+		if err != nil {
+			return err
+		}
+
+		if query(data) {
+			if err := t.write(id, updater(data)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *TxCollection[T]) Delete(query Query[T]) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	ids, err := t.ids()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		data, err := t.read(id)
+		if err != nil {
+			return err
+		}
+
+		if query(data) {
+			t.pending[id] = txRecord{deleted: true}
+		}
+	}
+
+	return nil
+}
+
+// deleteReferring applies the references to the collection to the records deleted since it was last called, and returns
+// whether there were any
+func (t *TxCollection[T]) deleteReferring() (bool, error) {
+	var ids []int
+	for id, rec := range t.pending {
+		if rec.deleted && !t.handled[id] {
+			t.handled[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return false, nil
+	}
+	sort.Ints(ids)
+
+	keys := make([]string, 0, len(t.c.state.refs))
+	for key := range t.c.state.refs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := t.c.state.refs[key].deleted(t.tx, ids); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// checkConstraints checks the final state of the records the transaction wrote against the indices of the collection
+func (t *TxCollection[T]) checkConstraints() error {
+	changes := make([]recordChange[T], 0, len(t.pending))
+	for id, rec := range t.pending {
+		change := recordChange[T]{fileID: strconv.Itoa(id), deleted: rec.deleted}
+
+		old, err := t.c.read(id)
+		if err == nil {
+			change.old = &old
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		if !rec.deleted {
+			if change.data, err = t.read(id); err != nil {
+				return err
+			}
+		}
+
+		changes = append(changes, change)
+	}
+
+	return t.c.checkConstraints(changes)
+}
+
+func (t *TxCollection[T]) entries(name string) []walEntry {
+	ids := make([]int, 0, len(t.pending))
+	for id := range t.pending {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	entries := make([]walEntry, 0, len(ids))
+	for _, id := range ids {
+		rec := t.pending[id]
+		entries = append(entries, walEntry{Collection: name, ID: id, Data: rec.data, Deleted: rec.deleted})
+	}
+
+	return entries
+}
+
+// apply writes one logged entry to the collection's storage and its indices, the collection is locked by the transaction
+func (t *TxCollection[T]) apply(entry walEntry) error {
+	fileID := strconv.Itoa(entry.ID)
+
+	old, err := t.c.read(entry.ID)
+	if err == nil {
+		t.c.removeFromIndices(fileID, old)
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if entry.Deleted {
+		if err := t.c.state.store.remove(entry.ID); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+
+	if err := t.c.state.store.put(entry.ID, entry.Data); err != nil {
+		return err
+	}
+
+	data, err := t.c.read(entry.ID)
+	if err != nil {
+		return err
+	}
+	t.c.addToIndices(fileID, data)
+
+	return nil
+}
+
+func (t *TxCollection[T]) unlock() {
+	t.c.state.mu.Unlock()
+}
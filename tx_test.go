@@ -0,0 +1,117 @@
+package gobble
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+type exampleOrder struct {
+	Item     string
+	Quantity int
+}
+
+type exampleStock struct {
+	Item  string
+	Count int
+}
+
+func TestTransactions(t *testing.T) {
+	db, _ := OpenDB("testdb-tx")
+	defer func() { _ = os.RemoveAll("testdb-tx") }()
+	orders, _ := OpenCollection[exampleOrder](db, "orders")
+	stock, _ := OpenCollection[exampleStock](db, "stock")
+	stockIndex, _ := OpenIndex[exampleStock, string](&stock, "item", func(s exampleStock) string { return s.Item })
+	_ = stock.Insert(exampleStock{Item: "Square", Count: 5})
+
+	placeOrder := func(quantity int) error {
+		return db.Update(func(tx *Tx) error {
+			if err := orders.Tx(tx).Insert(exampleOrder{Item: "Square", Quantity: quantity}); err != nil {
+				return err
+			}
+			err := stock.Tx(tx).Modify(func(s exampleStock) bool { return s.Item == "Square" },
+				func(s exampleStock) exampleStock { s.Count -= quantity; return s })
+			if err != nil {
+				return err
+			}
+
+			// Reads through the transaction see its own writes
+			s, _ := stock.Tx(tx).Select(func(s exampleStock) bool { return s.Item == "Square" })
+			if s[0].Count < 0 {
+				return errors.New("out of stock")
+			}
+			return nil
+		})
+	}
+
+	if err := placeOrder(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := placeOrder(3); err == nil || err.Error() != "out of stock" {
+		t.Fatalf("expected the transaction to fail, got %v", err)
+	}
+
+	o, _ := orders.Select(func(o exampleOrder) bool { return true })
+	if len(o) != 1 || o[0].Quantity != 3 {
+		t.Fatalf("expected only the first order, got %v", o)
+	}
+	s, _ := stockIndex.Get("Square")
+	if len(s) != 1 || s[0].Count != 2 {
+		t.Fatalf("expected stock of 2 through the index, got %v", s)
+	}
+
+	// A panic rolls back too, and the collections are usable afterwards
+	func() {
+		defer func() { _ = recover() }()
+		_ = db.Update(func(tx *Tx) error {
+			_ = orders.Tx(tx).Delete(func(o exampleOrder) bool { return true })
+			panic("oops")
+		})
+	}()
+	if n, _ := orders.Number(); n != 1 {
+		t.Fatalf("expected the order to survive the panicking transaction, got %d orders", n)
+	}
+
+	_ = db.Close()
+}
+
+func TestTransactionRecovery(t *testing.T) {
+	db, _ := OpenDB("testdb-txrecover")
+	defer func() { _ = os.RemoveAll("testdb-txrecover") }()
+	orders, _ := OpenCollection[exampleOrder](db, "orders")
+	stock, _ := OpenCollection[exampleStock](db, "stock", WithStorage(SegmentStorage))
+	_ = stock.Insert(exampleStock{Item: "Square", Count: 5})
+	_ = orders.Insert(exampleOrder{Item: "Circle", Quantity: 1})
+	_ = db.Close()
+
+	// What a process that stopped right after committing a transaction leaves behind, and a transaction it was in the
+	// middle of committing
+	order := encodeForTest(t, exampleOrder{Item: "Square", Quantity: 2})
+	newStock := encodeForTest(t, exampleStock{Item: "Square", Count: 3})
+	appendWALForTest(t, "testdb-txrecover", []walEntry{
+		{Collection: "orders", ID: 1, Deleted: true},
+		{Collection: "orders", ID: 2, Data: order},
+		{Collection: "stock", ID: 1, Data: newStock},
+	}, 0)
+	appendWALForTest(t, "testdb-txrecover", []walEntry{
+		{Collection: "orders", ID: 2, Deleted: true},
+		{Collection: "stock", ID: 1, Deleted: true},
+	}, 10)
+
+	db, err := OpenDB("testdb-txrecover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+	orders, _ = OpenCollection[exampleOrder](db, "orders")
+	stock, _ = OpenCollection[exampleStock](db, "stock")
+
+	o, _ := orders.Select(func(o exampleOrder) bool { return true })
+	if len(o) != 1 || o[0] != (exampleOrder{Item: "Square", Quantity: 2}) {
+		t.Fatalf("orders not recovered: %v", o)
+	}
+	s, _ := stock.GetByID(1)
+	if s.Count != 3 {
+		t.Fatalf("stock not recovered: %v", s)
+	}
+}
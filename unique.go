@@ -0,0 +1,125 @@
+package gobble
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Unique indices
+//
+// A unique index refuses writes that would give one of its keys to a second record. Every write path checks all the
+// records it is about to write against the indices before writing any of them, so a refused Modify, Index.Mod or
+// transaction leaves the collection as it was.
+
+// IndexOption configures OpenIndex and the other functions that open an index.
+type IndexOption func(*indexOptions)
+
+type indexOptions struct {
+	unique   bool
+	foldCase bool // only for PrefixIndex
+}
+
+// WithUnique makes the index unique: writes that would give a key of the index to more than one record fail with
+// *ErrDuplicateKey, and opening it fails the same way if the collection already has such records.
+func WithUnique() IndexOption {
+	return func(o *indexOptions) {
+		o.unique = true
+	}
+}
+
+func indexOptionsOf(opts []IndexOption) indexOptions {
+	var o indexOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// ErrDuplicateKey is returned by writes that would give a key of a unique index to a second record.
+// Use errors.As to get at the key and the ID of the record that already has it.
+type ErrDuplicateKey struct {
+	Index string
+	Key   any
+	ID    int // the record that already has the key
+}
+
+func (e *ErrDuplicateKey) Error() string {
+	return fmt.Sprintf("duplicate key %v in unique index %q, already used by record %d", e.Key, e.Index, e.ID)
+}
+
+// recordChange is a write of one record, as seen by the indices before it is made
+type recordChange[T any] struct {
+	fileID  string // "" for a record that is being inserted
+	old     *T     // nil for a record that is being inserted
+	data    T
+	deleted bool
+}
+
+// constrainer is implemented by indices that can refuse writes
+type constrainer[T any] interface {
+	checkWrites(changes []recordChange[T]) error
+}
+
+// checkConstraints returns an error if any index refuses changes, t.state.mu must be held
+func (t *Collection[T]) checkConstraints(changes []recordChange[T]) error {
+	for _, registered := range t.state.indices {
+		if index, ok := registered.(constrainer[T]); ok {
+			if err := index.checkWrites(changes); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkUnique returns *ErrDuplicateKey if, after changes, some key would belong to more than one record. keys returns
+// the keys of a record, and owners the file IDs the index currently has for a key.
+func checkUnique[T any, D comparable](name string, changes []recordChange[T], keys func(T) []D, owners func(D) []string) error {
+	changed := make(map[string]bool, len(changes))
+	for _, change := range changes {
+		if change.fileID != "" {
+			changed[change.fileID] = true
+		}
+	}
+
+	// Keys taken by the changes themselves, records that are changed get their keys checked on their own
+	claimed := make(map[D]int, len(changes))
+	for i, change := range changes {
+		if change.deleted {
+			continue
+		}
+
+		for _, key := range keys(change.data) {
+			if other, ok := claimed[key]; ok && other != i {
+				return duplicateKey(name, key, changes[other].fileID)
+			}
+			claimed[key] = i
+
+			for _, owner := range owners(key) {
+				if owner != change.fileID && !changed[owner] {
+					return duplicateKey(name, key, owner)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func duplicateKey(index string, key any, fileID string) error {
+	// Inserted records have no ID yet, they only conflict with each other within a transaction
+	id, _ := strconv.Atoi(fileID)
+	return &ErrDuplicateKey{Index: index, Key: key, ID: id}
+}
+
+// checkUniqueIndex returns *ErrDuplicateKey if a key of a freshly loaded or built index has more than one record
+func checkUniqueIndex[D comparable](name string, index map[D][]string) error {
+	for key, fileIDs := range index {
+		if len(fileIDs) > 1 {
+			return duplicateKey(name, key, fileIDs[0])
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,14 @@
 package gobble
 
-import "strings"
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
 
 func isValidCollectionName(name string) bool {
 	if len(name) == 0 {
@@ -14,7 +22,8 @@ func isValidCollectionName(name string) bool {
 		}
 	}
 
-	if strings.ContainsRune("-_", rune(name[0])) || strings.ContainsRune(".-_", rune(name[len(name)-1])) {
+	// Names starting with a '.' are kept for gobble's own files in the DB directory, like the lock file
+	if strings.ContainsRune(".-_", rune(name[0])) || strings.ContainsRune(".-_", rune(name[len(name)-1])) {
 		return false
 	}
 
@@ -39,3 +48,88 @@ func isValidIndexName(name string) bool {
 
 	return true
 }
+
+// tempFilePrefix starts with a '.' so that it never looks like a record file ("d*") while being written
+const tempFilePrefix = ".tmp-"
+
+// writeGobAtomic gob encodes v and writes it to path with writeFileAtomic
+func writeGobAtomic(path string, v any) error {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, buf.Bytes(), true)
+}
+
+// writeFileAtomic writes data into a temporary file in the same directory as path and renames it over path.
+// The file is synced before the rename, so even a crash of the whole machine at any point leaves either the old or the
+// new file at path, never a partially written one. With durable set the rename is synced too, so it isn't lost.
+func writeFileAtomic(path string, data []byte, durable bool) error {
+	dir := filepath.Dir(path)
+
+	f, err := os.CreateTemp(dir, tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if !durable {
+		return nil
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes renames and removals in dir durable
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		// Directories can't be opened for syncing on windows, renames are durable there once they return
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// removeTempFiles deletes temporary files left in dir by writes that never completed
+func removeTempFiles(dir string) error {
+	names, err := filepath.Glob(filepath.Join(dir, tempFilePrefix+"*"))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
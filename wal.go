@@ -0,0 +1,376 @@
+package gobble
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Write-ahead log
+//
+// Every write to a collection is first appended to the log file in the DB directory, and only then written to the
+// collection's storage, which doesn't sync its files itself. Once the log grows past walCheckpointSize (and when the DB
+// is closed) all storage is synced and the log is emptied, that's a checkpoint. OpenDB replays whatever is left in the
+// log, so any write that made it into the log is in the collections after a crash. Replaying an entry just overwrites or
+// removes one record, so it doesn't matter if it had already been applied.
+//
+// Each append is one batch of entries, framed as: length (4 bytes) | CRC32 of the payload (4) | gob encoded walBatch.
+// A batch is replayed completely or (if the process stopped while it was being appended) not at all, which is what
+// makes transactions atomic.
+
+var errDBClosed = errors.New("database is closed")
+
+const (
+	walFileName       = ".wal"
+	walFrameSize      = 8
+	walCheckpointSize = 4 << 20
+)
+
+// SyncMode sets when the write-ahead log is synced to disk, trading durability for write throughput.
+// A crash of the process never loses acknowledged writes, SyncMode is about crashes of the whole machine. Such a crash
+// can lose the writes whose log entries weren't synced yet, but it never leaves a record corrupt: FileStorage syncs each
+// record file before renaming it into place, and SegmentStorage drops a record torn at the end of its newest segment.
+type SyncMode int
+
+const (
+	// SyncAlways syncs the log before every write returns, so no acknowledged write is ever lost. The default.
+	SyncAlways SyncMode = iota
+	// SyncInterval syncs the log in the background, every 100ms or as set by WithSyncInterval. At most that much of the
+	// most recent writes can be lost.
+	SyncInterval
+	// SyncNever leaves syncing the log to the operating system. Transactions are still synced, so they remain atomic.
+	SyncNever
+)
+
+// WithSyncMode sets when the write-ahead log is synced, SyncAlways by default.
+func WithSyncMode(mode SyncMode) Option {
+	return func(o *options) {
+		o.syncMode = mode
+	}
+}
+
+// WithSyncInterval selects SyncInterval, syncing the write-ahead log every interval.
+func WithSyncInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.syncMode = SyncInterval
+		o.syncInterval = interval
+	}
+}
+
+type walBatch struct {
+	Entries []walEntry
+}
+
+type walEntry struct {
+	Collection string
+	ID         int
+	Data       []byte
+	Deleted    bool
+}
+
+type writeAheadLog struct {
+	path string
+	mode SyncMode
+
+	// Held shared from appending an entry until it is applied to storage, and exclusively by checkpoints,
+	// so a checkpoint never empties the log between the two.
+	cpMu sync.RWMutex
+
+	mu       sync.Mutex
+	f        *os.File // nil once closed
+	closing  bool
+	size     int64
+	unsynced bool
+
+	// Syncs the storage of all open collections
+	syncStores func() error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openWAL replays the log left by the last process, then empties it for this one
+func openWAL(path string, o options, syncStores func() error) (*writeAheadLog, error) {
+	if err := replayWAL(path, o); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path+"/"+walFileName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(0); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	w := &writeAheadLog{path: path, mode: o.syncMode, f: f, syncStores: syncStores}
+
+	if w.mode == SyncInterval {
+		interval := o.syncInterval
+		if interval <= 0 {
+			interval = 100 * time.Millisecond
+		}
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.syncEvery(interval)
+	}
+
+	return w, nil
+}
+
+func (w *writeAheadLog) syncEvery(interval time.Duration) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.unsynced && w.f != nil {
+				if err := w.f.Sync(); err == nil {
+					w.unsynced = false
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// log appends entries as one batch, then calls apply to write them to storage. If forceSync is set the batch is synced
+// whatever the SyncMode.
+func (w *writeAheadLog) log(entries []walEntry, forceSync bool, apply func() error) error {
+	w.cpMu.RLock()
+	err := w.append(entries, forceSync)
+	if err == nil {
+		err = apply()
+	}
+	w.cpMu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	full := w.size >= walCheckpointSize
+	w.mu.Unlock()
+
+	if full {
+		return w.checkpoint()
+	}
+	return nil
+}
+
+func (w *writeAheadLog) append(entries []walEntry, forceSync bool) error {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, walFrameSize))
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(walBatch{Entries: entries}); err != nil {
+		return err
+	}
+
+	frame := buf.Bytes()
+	payload := frame[walFrameSize:]
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return errDBClosed
+	}
+
+	// If this fails part way, the next append overwrites the partial batch since size isn't advanced
+	if _, err := w.f.WriteAt(frame, w.size); err != nil {
+		return err
+	}
+	w.size += int64(len(frame))
+	w.unsynced = true
+
+	if forceSync || w.mode == SyncAlways {
+		if err := w.f.Sync(); err != nil {
+			return err
+		}
+		w.unsynced = false
+	}
+
+	return nil
+}
+
+// checkpoint syncs all storage, after which the log isn't needed anymore
+func (w *writeAheadLog) checkpoint() error {
+	w.cpMu.Lock()
+	defer w.cpMu.Unlock()
+
+	if err := w.syncStores(); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil || w.size == 0 {
+		return nil
+	}
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	w.size = 0
+	w.unsynced = false
+
+	return nil
+}
+
+// close does a last checkpoint, so a cleanly closed DB has nothing to replay. Appending fails afterwards.
+func (w *writeAheadLog) close() error {
+	w.mu.Lock()
+	if w.closing {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closing = true
+	w.mu.Unlock()
+
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+
+	err := w.checkpoint()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	w.f = nil
+
+	return err
+}
+
+// readWAL returns the complete batches in the log file, ignoring a batch the last process didn't finish appending
+func readWAL(path string) ([]walBatch, error) {
+	f, err := os.Open(path + "/" + walFileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	r := bufio.NewReader(f)
+	frame := make([]byte, walFrameSize)
+	var batches []walBatch
+
+	for {
+		if _, err := io.ReadFull(r, frame); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return batches, nil
+			}
+			return nil, err
+		}
+
+		payload := make([]byte, binary.LittleEndian.Uint32(frame[0:4]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return batches, nil
+			}
+			return nil, err
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(frame[4:8]) {
+			return batches, nil
+		}
+
+		var batch walBatch
+		dec := gob.NewDecoder(bytes.NewReader(payload))
+		if err := dec.Decode(&batch); err != nil {
+			return batches, nil
+		}
+		batches = append(batches, batch)
+	}
+}
+
+// replayWAL applies the log left by the last process to the collections, and syncs them.
+// No collection is open yet, so there are no indices to update, the saved ones are removed to be rebuilt.
+func replayWAL(path string, o options) error {
+	batches, err := readWAL(path)
+	if err != nil {
+		return err
+	}
+
+	stores := map[string]storage{}
+	defer func() {
+		for _, store := range stores {
+			_ = store.close()
+		}
+	}()
+
+	for _, batch := range batches {
+		for _, entry := range batch.Entries {
+			store, ok := stores[entry.Collection]
+			if !ok {
+				if _, err := os.Stat(path + "/" + entry.Collection); errors.Is(err, os.ErrNotExist) {
+					// Deleted after the entry was logged
+					continue
+				}
+
+				store, err = openStorage(path+"/"+entry.Collection, o)
+				if err != nil {
+					return err
+				}
+				stores[entry.Collection] = store
+			}
+
+			if entry.Deleted {
+				err = store.remove(entry.ID)
+				if errors.Is(err, ErrNotFound) {
+					err = nil
+				}
+			} else {
+				err = store.put(entry.ID, entry.Data)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, store := range stores {
+		delete(stores, name)
+		err := store.sync()
+		if closeErr := store.close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := removeSavedIndices(path + "/" + name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
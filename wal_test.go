@@ -0,0 +1,111 @@
+package gobble
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// appendWALForTest appends a batch to the write-ahead log of the DB at path, leaving off the last cut bytes
+func appendWALForTest(t *testing.T, path string, entries []walEntry, cut int) {
+	f, err := os.OpenFile(path+"/"+walFileName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := f.Stat()
+	w := &writeAheadLog{f: f, size: info.Size()}
+	if err := w.append(entries, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(w.size - int64(cut)); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+}
+
+func TestWALReplay(t *testing.T) {
+	for _, storage := range []Storage{FileStorage, SegmentStorage} {
+		path := fmt.Sprintf("testdb-wal-%d", storage)
+		db, _ := OpenDB(path, WithStorage(storage))
+		c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+		_ = c.Insert(ExamplePersonStruct{Name: "ExamplePersonStruct 1", Age: 1})
+		_ = c.Insert(ExamplePersonStruct{Name: "ExamplePersonStruct 2", Age: 2})
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if info, _ := os.Stat(path + "/" + walFileName); info.Size() != 0 {
+			t.Fatal("log not emptied by Close")
+		}
+
+		// Writes that were logged, but not applied before the process stopped
+		appendWALForTest(t, path, []walEntry{{Collection: "testcollection", ID: 3, Data: encodeForTest(t, ExamplePersonStruct{Name: "ExamplePersonStruct 3", Age: 3})}}, 0)
+		appendWALForTest(t, path, []walEntry{{Collection: "testcollection", ID: 1, Deleted: true}}, 0)
+		appendWALForTest(t, path, []walEntry{{Collection: "othercollection", ID: 1, Deleted: true}}, 0)
+
+		db, err := OpenDB(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, _ = OpenCollection[ExamplePersonStruct](db, "testcollection")
+		x, _ := c.Select(func(p ExamplePersonStruct) bool { return true })
+		if !verifyItemsEqual(x, []ExamplePersonStruct{{Name: "ExamplePersonStruct 2", Age: 2}, {Name: "ExamplePersonStruct 3", Age: 3}}) {
+			t.Fatalf("log not replayed for storage %d: %v", storage, x)
+		}
+		if id, _ := c.InsertWithID(ExamplePersonStruct{Name: "ExamplePersonStruct 4", Age: 4}); id != 4 {
+			t.Fatalf("expected ID 4 after replay, got %d", id)
+		}
+
+		_ = db.Close()
+		_ = os.RemoveAll(path)
+	}
+}
+
+func TestSyncModes(t *testing.T) {
+	for _, opt := range []Option{WithSyncMode(SyncAlways), WithSyncInterval(time.Millisecond), WithSyncMode(SyncNever)} {
+		db, _ := OpenDB("testdb-syncmode", opt)
+		c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+		for i := 0; i < 20; i++ {
+			_ = c.Insert(ExamplePersonStruct{Name: fmt.Sprintf("ExamplePersonStruct %d", i), Age: i})
+		}
+		time.Sleep(5 * time.Millisecond)
+		if n, _ := c.Number(); n != 20 {
+			t.Fatalf("expected 20 records, got %d", n)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		db, _ = OpenDB("testdb-syncmode")
+		c, _ = OpenCollection[ExamplePersonStruct](db, "testcollection")
+		if n, _ := c.Number(); n != 20 {
+			t.Fatalf("expected 20 records after reopening, got %d", n)
+		}
+		_ = db.Close()
+		_ = os.RemoveAll("testdb-syncmode")
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	db, _ := OpenDB("testdb-closed")
+	defer func() {
+		_ = os.RemoveAll("testdb-closed")
+	}()
+	c, _ := OpenCollection[ExamplePersonStruct](db, "testcollection")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Insert(ExamplePersonStruct{Name: "ExamplePersonStruct 1"}); err == nil {
+		t.Fatal("expected an error inserting into a closed DB")
+	}
+	err := db.Update(func(tx *Tx) error {
+		return c.Tx(tx).Insert(ExamplePersonStruct{Name: "ExamplePersonStruct 2"})
+	})
+	if err == nil {
+		t.Fatal("expected an error committing to a closed DB")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("unexpected error closing twice: %v", err)
+	}
+}